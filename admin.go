@@ -0,0 +1,384 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/username"
+)
+
+const adminSnapshotTimeout = time.Second
+
+type adminUser struct {
+	Username    string    `json:"username"`
+	Room        string    `json:"room,omitempty"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	Version     int       `json:"version"`
+	Codec       string    `json:"codec"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	LastActive  time.Time `json:"lastActive"`
+	Detached    bool      `json:"detached"`
+	QueueDepth  int       `json:"queueDepth"`
+}
+
+type adminRoom struct {
+	Id       string            `json:"id"`
+	GameName string            `json:"gameName"`
+	Ruleset  int               `json:"ruleset"`
+	Players  []adminRoomPlayer `json:"players"`
+	Game     *adminGame        `json:"game,omitempty"`
+}
+
+type adminRoomPlayer struct {
+	Username string `json:"username"`
+	IsReady  bool   `json:"isReady"`
+	IsInGame bool   `json:"isInGame"`
+	Reserved bool   `json:"reserved"`
+}
+
+type adminGame struct {
+	Id    string `json:"id"`
+	Error string `json:"error,omitempty"`
+	game.Summary
+}
+
+type adminKickRequest struct {
+	Reason string `json:"reason"`
+}
+
+type adminBanRequest struct {
+	Kind     string `json:"kind"`
+	Value    string `json:"value"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+type adminAnnounceRequest struct {
+	Message string `json:"message"`
+}
+
+type adminMaintenance struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+func (l *Lobby) registerAdminRoutes(mux *http.ServeMux) {
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, l.withAdminAuth(h))
+	}
+	handle("GET /admin/v1/users", l.handlerAdminUsers)
+	handle("POST /admin/v1/users/{username}/kick", l.handlerAdminKick)
+	handle("GET /admin/v1/rooms", l.handlerAdminRooms)
+	handle("GET /admin/v1/rooms/{id}/game", l.handlerAdminGame)
+	handle("POST /admin/v1/rooms/{id}/terminate", l.handlerAdminTerminate)
+	handle("GET /admin/v1/bans", l.handlerAdminBans)
+	handle("POST /admin/v1/bans", l.handlerAdminBan)
+	handle("DELETE /admin/v1/bans/{kind}/{value}", l.handlerAdminUnban)
+	handle("POST /admin/v1/announce", l.handlerAdminAnnounce)
+	handle("GET /admin/v1/maintenance", l.handlerAdminMaintenance)
+	handle("PUT /admin/v1/maintenance", l.handlerAdminSetMaintenance)
+}
+
+func (l *Lobby) withAdminAuth(next http.Handler) http.Handler {
+	want := []byte("Bearer " + l.cfg.AdminToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			slog.Warn("admin: unauthorized request", "remote", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			respondWithError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *Lobby) handlerAdminUsers(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	users := slices.Clone(l.users)
+	l.mu.Unlock()
+
+	result := make([]adminUser, 0, len(users))
+	for _, u := range users {
+		u.mu.Lock()
+		au := adminUser{
+			Username:    u.username,
+			RemoteAddr:  u.remoteAddr,
+			Version:     u.version,
+			Codec:       u.codec.Name(),
+			ConnectedAt: u.connectedAt,
+			LastActive:  u.lastActive,
+			Detached:    u.conn == nil,
+			QueueDepth:  len(u.toUser),
+		}
+		if u.room != nil {
+			au.Room = u.room.id
+		}
+		u.mu.Unlock()
+		result = append(result, au)
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+func (l *Lobby) handlerAdminKick(w http.ResponseWriter, r *http.Request) {
+	req := adminKickRequest{}
+	err := decodeOptionalJSON(r, &req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "kicked by an administrator"
+	}
+
+	username := r.PathValue("username")
+	u := l.findUserByUsername(username)
+	if u == nil {
+		respondWithError(w, http.StatusNotFound, ErrUserNotExist.Error())
+		return
+	}
+	slog.Info("admin: kicking user", "user", username, "reason", req.Reason, "remote", r.RemoteAddr)
+	u.kick(req.Reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (l *Lobby) handlerAdminRooms(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	rooms := slices.Clone(l.rooms)
+	l.mu.Unlock()
+
+	// The games are asked for their state all at once, so that a few stuck
+	// ones delay the answer by adminSnapshotTimeout at most.
+	deadline := time.Now().Add(adminSnapshotTimeout)
+	result := make([]adminRoom, len(rooms))
+	wg := &sync.WaitGroup{}
+	for i, room := range rooms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result[i] = room.adminView(deadline)
+		}()
+	}
+	wg.Wait()
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+func (r *Room) adminView(deadline time.Time) adminRoom {
+	r.mu.RLock()
+	ar := adminRoom{
+		Id:       r.id,
+		GameName: r.game.Name(),
+		Ruleset:  r.indexRuleset,
+		Players:  make([]adminRoomPlayer, 0, len(r.players)),
+	}
+	for _, p := range r.players {
+		ar.Players = append(ar.Players, adminRoomPlayer{
+			Username: p.username,
+			IsReady:  p.isReady,
+			IsInGame: p.isInGame,
+			Reserved: p.user == nil,
+		})
+	}
+	toGame, gameId := r.toGame, r.gameId
+	r.mu.RUnlock()
+
+	if toGame == nil {
+		return ar
+	}
+	ar.Game = &adminGame{Id: gameId}
+	s, err := game.RequestSnapshot(toGame, time.Until(deadline))
+	if err != nil {
+		ar.Game.Error = err.Error()
+		return ar
+	}
+	ar.Game.Summary = s.Summary()
+	return ar
+}
+
+func (l *Lobby) handlerAdminGame(w http.ResponseWriter, r *http.Request) {
+	room := l.findRoomById(r.PathValue("id"))
+	if room == nil {
+		respondWithError(w, http.StatusNotFound, ErrRoomNotExist.Error())
+		return
+	}
+	room.mu.RLock()
+	toGame := room.toGame
+	room.mu.RUnlock()
+	if toGame == nil {
+		respondWithError(w, http.StatusNotFound, "no game is running in the room")
+		return
+	}
+
+	s, err := game.RequestSnapshot(toGame, adminSnapshotTimeout)
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, s)
+}
+
+func (l *Lobby) handlerAdminTerminate(w http.ResponseWriter, r *http.Request) {
+	room := l.findRoomById(r.PathValue("id"))
+	if room == nil {
+		respondWithError(w, http.StatusNotFound, ErrRoomNotExist.Error())
+		return
+	}
+	room.mu.RLock()
+	running := room.toGame != nil
+	room.mu.RUnlock()
+	if !running {
+		respondWithError(w, http.StatusNotFound, "no game is running in the room")
+		return
+	}
+
+	slog.Info("admin: terminating game", "room", room.id, "remote", r.RemoteAddr)
+	room.forwardToGame(Data{Type: "terminate"})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (l *Lobby) handlerAdminBans(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, l.bans.list())
+}
+
+func (l *Lobby) handlerAdminBan(w http.ResponseWriter, r *http.Request) {
+	req := adminBanRequest{}
+	err := decodeJSON(r, &req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Kind != banUser && req.Kind != banIP {
+		respondWithError(w, http.StatusBadRequest, "kind must be user or ip")
+		return
+	}
+	if req.Value == "" {
+		respondWithError(w, http.StatusBadRequest, "value must not be empty")
+		return
+	}
+	duration := time.Duration(0)
+	if req.Duration != "" {
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			respondWithError(w, http.StatusBadRequest, "duration must be positive, like 30m")
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "banned by an administrator"
+	}
+
+	b := l.bans.add(req.Kind, req.Value, req.Reason, duration)
+	slog.Info("admin: ban added", "kind", b.Kind, "value", b.Value, "reason", b.Reason, "remote", r.RemoteAddr)
+	for _, u := range l.bannedUsers(b) {
+		u.kick(b.Reason)
+	}
+	respondWithJSON(w, http.StatusCreated, b)
+}
+
+func (l *Lobby) bannedUsers(b ban) []*User {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := []*User{}
+	for _, u := range l.users {
+		if b.Kind == banUser && u.username != "" && username.Key(u.username) == username.Key(b.Value) || b.Kind == banIP && remoteIP(u.remoteAddr) == b.Value {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+func (l *Lobby) handlerAdminUnban(w http.ResponseWriter, r *http.Request) {
+	kind, value := r.PathValue("kind"), r.PathValue("value")
+	if !l.bans.remove(kind, value) {
+		respondWithError(w, http.StatusNotFound, "no such ban")
+		return
+	}
+	slog.Info("admin: ban removed", "kind", kind, "value", value, "remote", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (l *Lobby) handlerAdminAnnounce(w http.ResponseWriter, r *http.Request) {
+	req := adminAnnounceRequest{}
+	err := decodeJSON(r, &req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Message == "" {
+		respondWithError(w, http.StatusBadRequest, "message must not be empty")
+		return
+	}
+
+	n := l.announce(req.Message)
+	slog.Info("admin: announcement sent", "users", n, "remote", r.RemoteAddr)
+	respondWithJSON(w, http.StatusOK, struct {
+		Users int `json:"users"`
+	}{
+		Users: n,
+	})
+}
+
+func (l *Lobby) announce(message string) int {
+	l.mu.Lock()
+	users := slices.Clone(l.users)
+	l.mu.Unlock()
+
+	for _, u := range users {
+		u.enqueue(Data{
+			Type: "announcement",
+			Body: protocol.AnnouncementBody{
+				Message: message,
+			},
+		})
+	}
+	return len(users)
+}
+
+func (l *Lobby) handlerAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	enabled := l.maintenance
+	l.mu.Unlock()
+	respondWithJSON(w, http.StatusOK, adminMaintenance{Enabled: enabled})
+}
+
+func (l *Lobby) handlerAdminSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	req := adminMaintenance{}
+	err := decodeJSON(r, &req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	l.mu.Lock()
+	l.maintenance = req.Enabled
+	l.mu.Unlock()
+	slog.Info("admin: maintenance mode changed", "enabled", req.Enabled, "remote", r.RemoteAddr)
+	if req.Message != "" {
+		l.announce(req.Message)
+	}
+	respondWithJSON(w, http.StatusOK, adminMaintenance{Enabled: req.Enabled})
+}
+
+func decodeJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func decodeOptionalJSON(r *http.Request, v any) error {
+	err := decodeJSON(r, v)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
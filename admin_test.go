@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	cantstop "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/cant_stop"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/config"
+)
+
+const testAdminToken = "test-admin-token-0123"
+
+func (s *testServer) adminGet(path string, v any) {
+	s.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "http"+strings.TrimPrefix(s.url, "ws")+path, nil)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatalf("GET %s: %s", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		s.t.Fatalf("GET %s: got status %d", path, resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		s.t.Fatalf("GET %s: %s", path, err)
+	}
+}
+
+func TestAdminRoomsWithStuckGames(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.AdminToken = testAdminToken
+	})
+	g, err := lookupGame(cantstop.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing ever reads from the games of these rooms.
+	for i := 0; i < 3; i++ {
+		r := s.lobby.reservedRoom(fmt.Sprintf("STUCK%d", i), fmt.Sprintf("stuck-%d", i), g, 4, nil)
+		r.toGame = make(chan Data)
+		s.lobby.mu.Lock()
+		s.lobby.rooms = append(s.lobby.rooms, r)
+		s.lobby.mu.Unlock()
+	}
+
+	start := time.Now()
+	rooms := []adminRoom{}
+	s.adminGet("/admin/v1/rooms", &rooms)
+	if elapsed := time.Since(start); elapsed > 2*adminSnapshotTimeout {
+		t.Errorf("listing 3 stuck rooms took %s", elapsed)
+	}
+	if len(rooms) != 3 {
+		t.Fatalf("got %d rooms, want 3", len(rooms))
+	}
+	for _, r := range rooms {
+		if r.Game == nil || r.Game.Error == "" {
+			t.Errorf("room %s: got game %+v, want a snapshot error", r.Id, r.Game)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/username"
+)
+
+const (
+	banUser = "user"
+	banIP   = "ip"
+)
+
+type ban struct {
+	Kind   string     `json:"kind"`
+	Value  string     `json:"value"`
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+func (b ban) expired(now time.Time) bool {
+	return b.Until != nil && !now.Before(*b.Until)
+}
+
+// banList holds bans on usernames and IP addresses. A ban without an end
+// time lasts until it is removed.
+type banList struct {
+	mu   *sync.Mutex
+	bans map[string]ban
+}
+
+func newBanList() *banList {
+	return &banList{
+		mu:   &sync.Mutex{},
+		bans: map[string]ban{},
+	}
+}
+
+// banKey ignores the case of banned usernames, as usernames that differ
+// only in case cannot be used at the same time.
+func banKey(kind, value string) string {
+	if kind == banUser {
+		value = username.Key(value)
+	}
+	return kind + ":" + value
+}
+
+func (l *banList) add(kind, value, reason string, duration time.Duration) ban {
+	b := ban{
+		Kind:   kind,
+		Value:  value,
+		Reason: reason,
+	}
+	if duration > 0 {
+		until := time.Now().Add(duration)
+		b.Until = &until
+	}
+
+	l.mu.Lock()
+	l.bans[banKey(kind, value)] = b
+	l.mu.Unlock()
+	return b
+}
+
+func (l *banList) remove(kind, value string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.bans[banKey(kind, value)]
+	delete(l.bans, banKey(kind, value))
+	return ok
+}
+
+func (l *banList) check(kind, value string) (ban, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.bans[banKey(kind, value)]
+	if !ok {
+		return ban{}, false
+	}
+	if b.expired(time.Now()) {
+		delete(l.bans, banKey(kind, value))
+		return ban{}, false
+	}
+	return b, true
+}
+
+func (l *banList) list() []ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	result := []ban{}
+	for key, b := range l.bans {
+		if b.expired(now) {
+			delete(l.bans, key)
+			continue
+		}
+		result = append(result, b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result
+}
+
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
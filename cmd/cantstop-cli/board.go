@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// printBoard draws every path as a column, bottom to top. Each player's
+// marker is a letter (see playerList), upper case for the playing player's
+// temporary markers, and a claimed path is topped by its owner's letter.
+//
+//	.  .  a  .
+//	.  B  .  .
+//	2  3  4  5
+func (c *client) printBoard() {
+	if len(c.board.Gameboard) == 0 {
+		c.printf("No board yet.")
+		return
+	}
+	height := 0
+	for _, length := range c.pathLengths {
+		height = max(height, int(length))
+	}
+	claimed := map[int]int{}
+	for _, b := range c.board.BlockedPaths {
+		claimed[int(b.Path)] = int(b.Color)
+	}
+
+	lines := []string{}
+	top := &strings.Builder{}
+	for i, path := range c.board.Gameboard {
+		if len(path) == 0 {
+			continue
+		}
+		if n, ok := claimed[i]; ok {
+			fmt.Fprintf(top, " %c ", playerMark(n)-'a'+'A')
+		} else {
+			top.WriteString("   ")
+		}
+	}
+	if strings.TrimSpace(top.String()) != "" {
+		lines = append(lines, top.String())
+	}
+	for row := height - 1; row >= 0; row-- {
+		line := &strings.Builder{}
+		for _, path := range c.board.Gameboard {
+			if len(path) == 0 {
+				continue
+			}
+			if row >= len(path) {
+				line.WriteString("   ")
+				continue
+			}
+			fmt.Fprintf(line, "%3s", cell(path[row].Colors, path[row].HasTemp))
+		}
+		lines = append(lines, line.String())
+	}
+	footer := &strings.Builder{}
+	for i, path := range c.board.Gameboard {
+		if len(path) != 0 {
+			fmt.Fprintf(footer, "%3d", i)
+		}
+	}
+	lines = append(lines, footer.String())
+
+	for _, line := range lines {
+		c.printf("%s", strings.TrimRight(line, " "))
+	}
+}
+
+// cell shows the markers on a space. If there are several, the temporary
+// marker is shown if there is one, otherwise the number of markers.
+func cell(colors []int8, hasTemp bool) string {
+	switch {
+	case len(colors) == 0:
+		return "."
+	case hasTemp:
+		return string(playerMark(int(colors[len(colors)-1])) - 'a' + 'A')
+	case len(colors) == 1:
+		return string(playerMark(int(colors[0])))
+	default:
+		return fmt.Sprint(len(colors))
+	}
+}
+
+func playerMark(n int) rune {
+	return rune('a' + n%26)
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	cantstop "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/cant_stop"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/pig"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+// The client asks for version 1 so that the board always arrives whole in
+// gameboard messages.
+const protocolVersion = 1
+
+// ackEvery is how many messages the client receives before acknowledging
+// them, so the server can drop them from its replay buffer.
+const ackEvery = 32
+
+var errQuit = errors.New("quit")
+
+type client struct {
+	conn     *websocket.Conn
+	out      io.Writer
+	name     string
+	verbose  bool
+	seq      uint64
+	acked    uint64
+	username string
+	ruleset  int
+	// motion is the vote in progress, if any.
+	motion string
+
+	// Can't Stop
+	players     []string
+	pathLengths []int8
+	board       cantstop.GameboardBody
+	actions     [][]int8
+}
+
+func newClient(conn *websocket.Conn, out io.Writer, name string, verbose bool) *client {
+	return &client{
+		conn:    conn,
+		out:     out,
+		name:    name,
+		verbose: verbose,
+	}
+}
+
+func (c *client) run(messages <-chan incoming, lines <-chan string) error {
+	err := c.send("ready", protocol.ReadyBody{Versions: []int{protocolVersion}})
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case in, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			if in.err != nil {
+				if websocket.IsCloseError(in.err, websocket.CloseNormalClosure, websocket.CloseServiceRestart) {
+					c.printf("Connection closed: %s", in.err)
+					return nil
+				}
+				return in.err
+			}
+			err = c.handle(in.msg)
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+			err = c.command(strings.Fields(line))
+		}
+		if errors.Is(err, errQuit) {
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return nil
+		}
+		if err != nil {
+			c.printf("Error: %s", err)
+		}
+	}
+}
+
+func (c *client) printf(format string, args ...any) {
+	fmt.Fprintf(c.out, format+"\n", args...)
+}
+
+func (c *client) send(typ string, body any) error {
+	data, err := json.Marshal(protocol.Data{
+		Type: typ,
+		Body: body,
+	})
+	if err != nil {
+		return err
+	}
+	if c.verbose {
+		c.printf("> %s", data)
+	}
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (c *client) handle(m message) error {
+	if c.verbose {
+		c.printf("< %d %s %s", m.Seq, m.Type, m.Body)
+	}
+	if m.Seq > c.seq {
+		c.seq = m.Seq
+	}
+	if c.seq-c.acked >= ackEvery {
+		c.acked = c.seq
+		err := c.send("ack", protocol.AckBody{Seq: c.seq})
+		if err != nil {
+			return err
+		}
+	}
+
+	switch m.Type {
+	case "version", "session", "replayUnavailable":
+		return nil
+	case "username":
+		if c.name != "" {
+			c.username = c.name
+			c.name = ""
+			return c.send("username", protocol.UsernameBody{Username: c.username})
+		}
+		c.printf("Choose a username: name <username>")
+	case "error":
+		body := protocol.ErrorBody{}
+		json.Unmarshal(m.Body, &body)
+		if body.Code != "" {
+			c.printf("Error (%s): %s", body.Code, body.Error)
+		} else {
+			c.printf("Error: %s", body.Error)
+		}
+	case "prep":
+		c.printf("In the lobby: new, join <room>")
+	case "prepUpdate":
+		body := protocol.PrepUpdateBody{}
+		json.Unmarshal(m.Body, &body)
+		ready := "not ready"
+		if body.IsReady {
+			ready = "ready"
+		}
+		c.ruleset = body.Ruleset
+		options := ""
+		if body.AllowUndo {
+			options += ", undo allowed"
+		}
+		if body.FinishRound {
+			options += ", round finished"
+		}
+		if len(body.TieBreakers) != 0 {
+			options += ", ties broken by " + strings.Join(body.TieBreakers, ", ")
+		}
+		c.printf("Room %s, %s rule set %d%s, players %s, you are %s", body.RoomId, body.Game, body.Ruleset, options, strings.Join(body.Usernames, ", "), ready)
+		if body.IsHosting {
+			c.printf("You host: game <name> <ruleset>, ruleset <n>, allowundo on|off, finishround on|off, tiebreakers [<name>...], start")
+		} else {
+			c.printf("ready, unready, leave")
+		}
+	case "maintenance":
+		body := protocol.MaintenanceBody{}
+		json.Unmarshal(m.Body, &body)
+		c.printf("Maintenance: %s", body.Message)
+	case "announcement":
+		body := protocol.AnnouncementBody{}
+		json.Unmarshal(m.Body, &body)
+		c.printf("Announcement: %s", body.Message)
+	case "log":
+		body := game.LogBody{}
+		json.Unmarshal(m.Body, &body)
+		c.printf("* %s", body.Content)
+	case "winner":
+		body := game.WinnerBody{}
+		json.Unmarshal(m.Body, &body)
+		if len(body.Winners) > 1 {
+			c.printf("%s share the win! Type exit to go back to the room.", strings.Join(body.Winners, ", "))
+		} else {
+			c.printf("%s wins! Type exit to go back to the room.", body.Winner)
+		}
+	case "voteUpdate":
+		body := protocol.VoteUpdateBody{}
+		json.Unmarshal(m.Body, &body)
+		c.printVote(body)
+	case "paused":
+		body := game.PausedBody{}
+		json.Unmarshal(m.Body, &body)
+		if body.Paused {
+			c.printf("The game is paused. Type unpause to vote to go on.")
+		}
+	default:
+		return c.handleGame(m)
+	}
+	return nil
+}
+
+func (c *client) handleGame(m message) error {
+	switch m.Type {
+	case "start":
+		body := cantstop.StartBody{}
+		json.Unmarshal(m.Body, &body)
+		c.players = body.Usernames
+		c.pathLengths = body.PathLengths
+		c.board = cantstop.GameboardBody{}
+		c.printf("Game starts with %s", c.playerList())
+	case "gameboard":
+		json.Unmarshal(m.Body, &c.board)
+		c.printBoard()
+	case "turnCount":
+		body := cantstop.TurnCountBody{}
+		json.Unmarshal(m.Body, &body)
+		c.printf("Turn %d", body.TurnCount)
+	case "player":
+		body := cantstop.PlayerBody{}
+		json.Unmarshal(m.Body, &body)
+		if body.IsPlaying {
+			c.printf("%s is playing with %d paths claimed", body.Username, body.Score)
+		}
+	case "moveCount":
+	case "roll":
+		c.printf("Your move: roll")
+	case "result":
+		body := cantstop.ResultBody{}
+		json.Unmarshal(m.Body, &body)
+		c.printResult(body)
+	case "confirm":
+		body := cantstop.ConfirmPromptBody{}
+		json.Unmarshal(m.Body, &body)
+		if body.CanUndo {
+			c.printf("Keep going or stop: go, stop, or undo")
+		} else {
+			c.printf("Keep going or stop: go, stop")
+		}
+	case "undo":
+	case "standings":
+		body := cantstop.StandingsBody{}
+		json.Unmarshal(m.Body, &body)
+		for _, s := range body.Standings {
+			rank := strconv.Itoa(s.Rank) + "."
+			if s.Tied {
+				rank = "=" + rank
+			}
+			c.printf("%s %s: %d paths claimed, %d spaces climbed, %d moves", rank, s.Username, s.Claimed, s.Progress, s.TotalMoves)
+		}
+	case "pigState":
+		body := pig.PigStateBody{}
+		json.Unmarshal(m.Body, &body)
+		c.printPig(body)
+	default:
+		c.printf("Unhandled message %s %s", m.Type, m.Body)
+	}
+	return nil
+}
+
+func (c *client) printVote(body protocol.VoteUpdateBody) {
+	switch body.Result {
+	case "":
+		c.motion = body.Motion
+		c.printf("Vote to %s: %s of %s agree. Type %s to agree or no to reject.",
+			body.Motion, strings.Join(body.Votes, ", "), strings.Join(body.Voters, ", "), body.Motion)
+	default:
+		c.motion = ""
+		c.printf("Vote to %s %s", body.Motion, body.Result)
+	}
+}
+
+func (c *client) printResult(body cantstop.ResultBody) {
+	c.printf("Rolled %v", body.Points)
+	if body.Failed {
+		c.printf("No valid actions. Type ok to end your turn.")
+		return
+	}
+	c.actions = nil
+	for _, o := range body.Options {
+		for _, a := range o.Actions {
+			c.actions = append(c.actions, a)
+			c.printf("  %d) advance %v  (%v)", len(c.actions), a, o.Grouping)
+		}
+	}
+	c.printf("Choose: act <n>")
+}
+
+func (c *client) printPig(body pig.PigStateBody) {
+	scores := make([]string, len(body.Usernames))
+	for i, username := range body.Usernames {
+		scores[i] = fmt.Sprintf("%s %d", username, body.Scores[i])
+	}
+	c.printf("Turn %d, goal %d: %s", body.TurnCount, body.Goal, strings.Join(scores, ", "))
+	if body.Ended {
+		return
+	}
+	if len(body.LastRoll) > 0 {
+		c.printf("%s rolled %v, turn total %d", body.Playing, body.LastRoll, body.TurnTotal)
+	}
+	if strings.EqualFold(body.Playing, c.username) {
+		c.printf("Your move: roll, hold")
+	}
+}
+
+func (c *client) playerList() string {
+	names := make([]string, len(c.players))
+	for i, p := range c.players {
+		names[i] = fmt.Sprintf("%c=%s", playerMark(i), p)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (c *client) command(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	switch args[0] {
+	case "help":
+		c.printf(helpText)
+		return nil
+	case "quit":
+		return errQuit
+	case "name":
+		if len(args) < 2 {
+			return errors.New("usage: name <username>")
+		}
+		c.username = strings.Join(args[1:], " ")
+		return c.send("username", protocol.UsernameBody{Username: c.username})
+	case "new":
+		return c.send("prepNew", nil)
+	case "join":
+		if len(args) != 2 {
+			return errors.New("usage: join <room>")
+		}
+		return c.send("prepJoin", protocol.PrepJoinBody{RoomId: strings.ToUpper(args[1])})
+	case "leave":
+		return c.send("prepLeave", nil)
+	case "ready":
+		return c.send("prepReady", nil)
+	case "unready":
+		return c.send("prepUnready", nil)
+	case "start":
+		return c.send("start", nil)
+	case "ruleset":
+		if len(args) != 2 {
+			return errors.New("usage: ruleset <n>")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		return c.send("ruleset", protocol.RulesetBody{Ruleset: n})
+	case "allowundo":
+		if len(args) != 2 || args[1] != "on" && args[1] != "off" {
+			return errors.New("usage: allowundo on|off")
+		}
+		allowUndo := args[1] == "on"
+		return c.send("ruleset", protocol.RulesetBody{Ruleset: c.ruleset, AllowUndo: &allowUndo})
+	case "finishround":
+		if len(args) != 2 || args[1] != "on" && args[1] != "off" {
+			return errors.New("usage: finishround on|off")
+		}
+		finishRound := args[1] == "on"
+		return c.send("ruleset", protocol.RulesetBody{Ruleset: c.ruleset, FinishRound: &finishRound})
+	case "tiebreakers":
+		return c.send("ruleset", protocol.RulesetBody{Ruleset: c.ruleset, TieBreakers: append([]string{}, args[1:]...)})
+	case "game":
+		if len(args) != 3 {
+			return errors.New("usage: game <name> <ruleset>")
+		}
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return err
+		}
+		return c.send("ruleset", protocol.RulesetBody{Game: args[1], Ruleset: n})
+	case "roll":
+		return c.send("roll", nil)
+	case "hold":
+		return c.send("hold", nil)
+	case "act":
+		if len(args) != 2 {
+			return errors.New("usage: act <n>")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > len(c.actions) {
+			return fmt.Errorf("choose an action from 1 to %d", len(c.actions))
+		}
+		return c.send("act", cantstop.ActBody{Action: c.actions[n-1]})
+	case "go", "stop":
+		willContinue := args[0] == "go"
+		return c.send("confirm", cantstop.ConfirmBody{WillContinue: &willContinue})
+	case "ok":
+		return c.send("confirm", cantstop.ConfirmBody{})
+	case "undo":
+		return c.send("undo", nil)
+	case "board":
+		c.printBoard()
+		return nil
+	case "resync":
+		return c.send("resync", nil)
+	case "exit":
+		return c.send("exit", nil)
+	case "pause", "unpause", "abort":
+		return c.send("vote", protocol.VoteBody{Motion: args[0], Agree: true})
+	case "no":
+		if c.motion == "" {
+			return errors.New("nothing to vote on")
+		}
+		return c.send("vote", protocol.VoteBody{Motion: c.motion, Agree: false})
+	case "raw":
+		if len(args) < 2 {
+			return errors.New("usage: raw <json>")
+		}
+		data := strings.Join(args[1:], " ")
+		if !json.Valid([]byte(data)) {
+			return errors.New("not valid JSON")
+		}
+		return c.conn.WriteMessage(websocket.TextMessage, []byte(data))
+	}
+	return fmt.Errorf("unknown command %s, type help for the list", args[0])
+}
+
+const helpText = `Lobby:      name <username>, new, join <room>, leave, quit
+Room:       ready, unready, ruleset <n>, game <name> <ruleset>, allowundo on|off,
+            finishround on|off, tiebreakers [<name>...], start
+Can't Stop: roll, act <n>, go, stop, undo, ok, board
+Pig:        roll, hold
+Game:       resync, exit, pause, unpause, abort, no
+Debugging:  raw <json>`
@@ -0,0 +1,95 @@
+// Command cantstop-cli is a terminal client for the game server, meant for
+// playing and debugging without the web front end.
+//
+//	go run ./cmd/cantstop-cli -addr ws://localhost:8080 -name alice
+//
+// Run the server with -dev-mode so that it accepts the client's origin.
+// Type help once connected for the list of commands.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/websocket"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+// message is a message from the server with its body left undecoded.
+type message struct {
+	Seq  uint64          `json:"seq"`
+	Type string          `json:"type"`
+	Body json.RawMessage `json:"body"`
+}
+
+func main() {
+	addr := flag.String("addr", "ws://localhost:80", "websocket address of the server")
+	origin := flag.String("origin", "http://localhost", "Origin header sent to the server")
+	name := flag.String("name", "", "username to take when the server asks for one")
+	verbose := flag.Bool("v", false, "print every message received")
+	flag.Parse()
+
+	header := http.Header{}
+	header.Set("Origin", *origin)
+	dialer := websocket.Dialer{
+		Subprotocols: []string{protocol.SubprotocolJSON},
+	}
+	conn, _, err := dialer.Dial(*addr, header)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to %s: %s\n", *addr, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	c := newClient(conn, os.Stdout, *name, *verbose)
+	err = c.run(readMessages(conn), readLines(os.Stdin))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+type incoming struct {
+	msg message
+	err error
+}
+
+// readMessages delivers the messages from the server until the connection
+// is closed, then the error that closed it.
+func readMessages(conn *websocket.Conn) <-chan incoming {
+	ch := make(chan incoming)
+	go func() {
+		defer close(ch)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				ch <- incoming{err: err}
+				return
+			}
+			m := message{}
+			err = json.Unmarshal(data, &m)
+			if err != nil {
+				ch <- incoming{err: fmt.Errorf("cannot decode message: %w", err)}
+				return
+			}
+			ch <- incoming{msg: m}
+		}
+	}()
+	return ch
+}
+
+func readLines(f *os.File) <-chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			ch <- scanner.Text()
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	cantstop "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/cant_stop"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/pig"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+// responses maps each request a bot sends to the message that answers it,
+// which is when its latency is measured.
+var responses = map[string]map[string]string{
+	cantstop.Name: {
+		"ready":    "username",
+		"username": "prep",
+		"prepNew":  "prepUpdate",
+		"prepJoin": "prepUpdate",
+		"start":    "start",
+		"roll":     "result",
+		"act":      "confirm",
+		"continue": "result",
+	},
+	pig.Name: {
+		"ready":    "username",
+		"username": "prep",
+		"prepNew":  "prepUpdate",
+		"prepJoin": "prepUpdate",
+		"start":    "pigState",
+		"roll":     "pigState",
+		"hold":     "pigState",
+	},
+}
+
+type message struct {
+	Seq  uint64          `json:"seq"`
+	Type string          `json:"type"`
+	Body json.RawMessage `json:"body"`
+}
+
+type request struct {
+	typ    string
+	sentAt time.Time
+}
+
+// A bot plays games in one room until it has played cfg.games of them. The
+// host creates the room and tells the others its id on roomIds.
+type bot struct {
+	cfg      config
+	stats    *stats
+	rd       *rand.Rand
+	conn     *websocket.Conn
+	username string
+	host     bool
+	roomIds  chan string
+	pending  map[string]request
+	seq      uint64
+	acked    uint64
+	played   int
+	backoff  time.Duration
+	inGame   bool
+	starting bool
+}
+
+func (b *bot) run(ctx context.Context) error {
+	header := http.Header{}
+	header.Set("Origin", b.cfg.origin)
+	dialer := websocket.Dialer{
+		Subprotocols:     []string{protocol.SubprotocolJSON},
+		HandshakeTimeout: 10 * time.Second,
+	}
+	conn, _, err := dialer.DialContext(ctx, b.cfg.addr, header)
+	if err != nil {
+		b.stats.count(&b.stats.dialFailures)
+		return err
+	}
+	b.conn = conn
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	b.pending = map[string]request{}
+	b.send("ready", protocol.ReadyBody{Versions: []int{protocol.Version}})
+	for b.played < b.cfg.games {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			b.stats.count(&b.stats.disconnects)
+			return err
+		}
+		m := message{}
+		err = json.Unmarshal(data, &m)
+		if err != nil {
+			return err
+		}
+		b.observe(m.Type)
+		b.ack(m.Seq)
+		if b.cfg.think > 0 {
+			time.Sleep(time.Duration(b.rd.Int63n(int64(b.cfg.think))))
+		}
+		err = b.handle(ctx, m)
+		if err != nil {
+			return err
+		}
+	}
+	// Leave the room before closing so that the server does not keep it
+	// while waiting for the bots to resume their sessions.
+	b.send("prepLeave", nil)
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return nil
+}
+
+func (b *bot) send(typ string, body any) {
+	b.sendAs(typ, typ, body)
+}
+
+// sendAs sends a message of type typ and measures its latency as label.
+func (b *bot) sendAs(label string, typ string, body any) {
+	if response, ok := responses[b.cfg.game][label]; ok {
+		if _, waiting := b.pending[response]; !waiting {
+			b.pending[response] = request{typ: label, sentAt: time.Now()}
+		}
+	}
+	data, _ := json.Marshal(protocol.Data{Type: typ, Body: body})
+	b.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// ack acknowledges messages now and then so that the server can drop them
+// from its replay buffer, as a real client would.
+func (b *bot) ack(seq uint64) {
+	b.seq = max(b.seq, seq)
+	if b.seq-b.acked >= ackEvery {
+		b.acked = b.seq
+		b.send("ack", protocol.AckBody{Seq: b.seq})
+	}
+}
+
+func (b *bot) observe(typ string) {
+	req, ok := b.pending[typ]
+	if !ok {
+		return
+	}
+	delete(b.pending, typ)
+	b.stats.observe(req.typ, time.Since(req.sentAt))
+}
+
+func (b *bot) handle(ctx context.Context, m message) error {
+	switch m.Type {
+	case "username":
+		if b.backoff > 0 {
+			time.Sleep(b.backoff)
+		}
+		b.send("username", protocol.UsernameBody{Username: b.username})
+	case "prep":
+		if b.backoff > 0 {
+			time.Sleep(b.backoff)
+		}
+		if b.host {
+			b.send("prepNew", nil)
+			return nil
+		}
+		select {
+		case id := <-b.roomIds:
+			b.roomIds <- id
+			b.send("prepJoin", protocol.PrepJoinBody{RoomId: id})
+		case <-ctx.Done():
+		}
+	case "prepUpdate":
+		body := protocol.PrepUpdateBody{}
+		json.Unmarshal(m.Body, &body)
+		b.backoff = 0
+		b.prepUpdate(body)
+	case "error":
+		body := protocol.ErrorBody{}
+		json.Unmarshal(m.Body, &body)
+		code := body.Code
+		if code == "" {
+			code = body.Error
+		}
+		b.stats.error(code)
+		b.pending = map[string]request{}
+		b.starting = false
+		b.backoff = min(max(2*b.backoff, 50*time.Millisecond), 2*time.Second)
+		if code == "usernameTaken" {
+			// Probably a bot of an earlier run whose session has not expired.
+			b.username += "x"
+		}
+		if code == "rateLimited" {
+			time.Sleep(time.Duration(body.RetryAfterMs) * time.Millisecond)
+		}
+	case "start", "pigState":
+		if !b.inGame {
+			b.inGame = true
+			b.starting = false
+			if b.host {
+				b.stats.count(&b.stats.gamesStarted)
+			}
+		}
+		if m.Type == "pigState" {
+			body := pig.PigStateBody{}
+			json.Unmarshal(m.Body, &body)
+			b.playPig(body)
+		}
+	case "roll":
+		b.send("roll", nil)
+	case "result":
+		body := cantstop.ResultBody{}
+		json.Unmarshal(m.Body, &body)
+		b.act(body)
+	case "confirm":
+		willContinue := b.rd.Intn(3) > 0
+		if willContinue {
+			b.sendAs("continue", "confirm", cantstop.ConfirmBody{WillContinue: &willContinue})
+		} else {
+			b.send("confirm", cantstop.ConfirmBody{WillContinue: &willContinue})
+		}
+	case "winner":
+		if b.host {
+			b.stats.count(&b.stats.gamesFinished)
+		}
+		b.leaveGame()
+	case "log":
+		body := game.LogBody{}
+		json.Unmarshal(m.Body, &body)
+		if strings.HasPrefix(body.Content, "game terminated:") {
+			if b.host {
+				b.stats.count(&b.stats.gamesEnded)
+			}
+			b.leaveGame()
+		}
+	case "replayUnavailable":
+		b.stats.error(m.Type)
+	}
+	return nil
+}
+
+func (b *bot) prepUpdate(body protocol.PrepUpdateBody) {
+	if b.host && b.roomIds != nil {
+		b.roomIds <- body.RoomId
+		b.roomIds = nil
+		if body.Game != b.cfg.game || body.Ruleset != b.cfg.ruleset {
+			b.send("ruleset", protocol.RulesetBody{Game: b.cfg.game, Ruleset: b.cfg.ruleset})
+		}
+		return
+	}
+	if b.inGame {
+		return
+	}
+	if !b.host && !body.IsReady {
+		b.send("prepReady", nil)
+		return
+	}
+	if b.host && body.IsReady && !b.starting && len(body.Usernames) == b.cfg.players &&
+		body.Game == b.cfg.game && body.Ruleset == b.cfg.ruleset {
+		b.starting = true
+		b.send("start", nil)
+	}
+}
+
+// act picks a random action among the ones offered. When the roll failed,
+// it ends the turn.
+func (b *bot) act(body cantstop.ResultBody) {
+	if body.Failed {
+		b.send("confirm", cantstop.ConfirmBody{})
+		return
+	}
+	actions := [][]int8{}
+	for _, o := range body.Options {
+		actions = append(actions, o.Actions...)
+	}
+	if len(actions) == 0 {
+		return
+	}
+	b.send("act", cantstop.ActBody{Action: actions[b.rd.Intn(len(actions))]})
+}
+
+func (b *bot) playPig(body pig.PigStateBody) {
+	if body.Ended {
+		return
+	}
+	if !strings.EqualFold(body.Playing, b.username) {
+		return
+	}
+	if body.TurnTotal >= 15+b.rd.Intn(15) {
+		b.send("hold", nil)
+	} else {
+		b.send("roll", nil)
+	}
+}
+
+func (b *bot) leaveGame() {
+	if !b.inGame {
+		return
+	}
+	b.inGame = false
+	b.played++
+	b.pending = map[string]request{}
+	b.send("exit", nil)
+}
+
+func botName(prefix string, room, seat int) string {
+	return fmt.Sprintf("%s%d-%d", prefix, room, seat)
+}
@@ -0,0 +1,187 @@
+// Command cantstop-load plays many games at once against a server with
+// scripted bots making random valid moves, then reports the latency of each
+// kind of request, the errors the bots received and the connections the
+// server dropped.
+//
+//	go run ./cmd/cantstop-load -addr ws://localhost:8080 -rooms 50 -ramp 10s
+//
+// Run the server with -dev-mode so that it accepts the bots' origin, and
+// raise -max-num-users-total, -max-num-rooms, the rate limits and the message
+// quotas so that they do not get in the way. Pass -queues to compare the
+// server's send queue statistics before and after the run; it needs
+// -enable-queue-stats.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+)
+
+// ackEvery is how many messages a bot receives before acknowledging them.
+const ackEvery = 32
+
+type config struct {
+	addr     string
+	origin   string
+	rooms    int
+	players  int
+	ramp     time.Duration
+	games    int
+	game     string
+	ruleset  int
+	think    time.Duration
+	duration time.Duration
+	prefix   string
+	queues   string
+	seed     int64
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.addr, "addr", "ws://localhost:80", "websocket address of the server")
+	flag.StringVar(&cfg.origin, "origin", "http://localhost", "Origin header sent to the server")
+	flag.IntVar(&cfg.rooms, "rooms", 10, "number of rooms playing at once")
+	flag.IntVar(&cfg.players, "players", 2, "number of bots in each room")
+	flag.DurationVar(&cfg.ramp, "ramp", 0, "time over which the rooms are opened")
+	flag.IntVar(&cfg.games, "games", 1, "number of games each room plays")
+	flag.StringVar(&cfg.game, "game", "cantstop", "game to play: cantstop or pig")
+	flag.IntVar(&cfg.ruleset, "ruleset", 0, "rule set to play, 0 for the game's first")
+	flag.DurationVar(&cfg.think, "think", 0, "longest a bot waits before answering a message")
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Minute, "time after which the run is stopped")
+	flag.StringVar(&cfg.prefix, "prefix", "bot", "prefix of the bots' usernames")
+	flag.StringVar(&cfg.queues, "queues", "", "URL of the server's /v1/queues endpoint")
+	flag.Int64Var(&cfg.seed, "seed", time.Now().UnixNano(), "seed of the bots' random moves")
+	flag.Parse()
+
+	err := cfg.check()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	before, err := fetchQueues(cfg.queues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error fetching queue statistics: %s\n", err)
+	}
+
+	s := newStats()
+	start := time.Now()
+	run(ctx, cfg, s)
+	elapsed := time.Since(start)
+
+	s.report(os.Stdout, elapsed)
+	if before != nil {
+		after, err := fetchQueues(cfg.queues)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching queue statistics: %s\n", err)
+			return
+		}
+		fmt.Printf("Server queues: %d messages dropped, %d users disconnected, deepest queue %d of %d\n",
+			after.Dropped-before.Dropped, after.Disconnects-before.Disconnects, after.MaxDepth, after.Capacity)
+	}
+}
+
+func (cfg *config) check() error {
+	g, err := game.Lookup(cfg.game)
+	if err != nil {
+		return err
+	}
+	if _, ok := responses[g.Name()]; !ok {
+		return fmt.Errorf("the bots cannot play %s", g.Name())
+	}
+	if cfg.ruleset == 0 {
+		cfg.ruleset = g.Rulesets()[0].Index
+	}
+	r, err := game.FindRuleset(g, cfg.ruleset)
+	if err != nil {
+		return err
+	}
+	err = r.CheckPlayers(cfg.players)
+	if err != nil {
+		return err
+	}
+	if cfg.rooms < 1 || cfg.games < 1 {
+		return fmt.Errorf("-rooms and -games must be at least 1")
+	}
+	return nil
+}
+
+// run opens the rooms evenly over cfg.ramp and waits for every bot to
+// finish its games or for ctx to be done.
+func run(ctx context.Context, cfg config, s *stats) {
+	wg := &sync.WaitGroup{}
+	interval := cfg.ramp / time.Duration(cfg.rooms)
+	for room := 0; room < cfg.rooms; room++ {
+		if room > 0 && interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		roomIds := make(chan string, 1)
+		for seat := 0; seat < cfg.players; seat++ {
+			b := &bot{
+				cfg:      cfg,
+				stats:    s,
+				rd:       rand.New(rand.NewSource(cfg.seed + int64(room*cfg.players+seat))),
+				username: botName(cfg.prefix, room, seat),
+				host:     seat == 0,
+				roomIds:  roomIds,
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := b.run(ctx)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %s\n", b.username, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+type queueStats struct {
+	Capacity    int   `json:"capacity"`
+	MaxDepth    int64 `json:"maxDepth"`
+	Dropped     int64 `json:"dropped"`
+	Disconnects int64 `json:"disconnects"`
+}
+
+func fetchQueues(url string) (*queueStats, error) {
+	if url == "" {
+		return nil, nil
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	stats := &queueStats{}
+	err = json.NewDecoder(resp.Body).Decode(stats)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
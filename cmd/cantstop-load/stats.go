@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// stats collects what the bots observe. It is shared by every bot.
+type stats struct {
+	mu            *sync.Mutex
+	latencies     map[string][]time.Duration
+	errors        map[string]int
+	dialFailures  int
+	disconnects   int
+	gamesStarted  int
+	gamesFinished int
+	gamesEnded    int
+}
+
+func newStats() *stats {
+	return &stats{
+		mu:        &sync.Mutex{},
+		latencies: map[string][]time.Duration{},
+		errors:    map[string]int{},
+	}
+}
+
+func (s *stats) observe(typ string, d time.Duration) {
+	s.mu.Lock()
+	s.latencies[typ] = append(s.latencies[typ], d)
+	s.mu.Unlock()
+}
+
+func (s *stats) count(field *int) {
+	s.mu.Lock()
+	*field++
+	s.mu.Unlock()
+}
+
+func (s *stats) error(code string) {
+	s.mu.Lock()
+	s.errors[code]++
+	s.mu.Unlock()
+}
+
+func (s *stats) report(w io.Writer, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(w, "Ran for %s\n\n", elapsed.Round(time.Millisecond))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "type\tcount\tp50\tp90\tp99\tmax\t")
+	types := make([]string, 0, len(s.latencies))
+	for typ := range s.latencies {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		ds := slices.Clone(s.latencies[typ])
+		slices.Sort(ds)
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t\n", typ, len(ds),
+			percentile(ds, 50), percentile(ds, 90), percentile(ds, 99), ds[len(ds)-1].Round(time.Microsecond))
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nGames: %d started, %d won, %d ended without a winner\n", s.gamesStarted, s.gamesFinished, s.gamesEnded)
+	fmt.Fprintf(w, "Connections: %d failed to connect, %d closed unexpectedly\n", s.dialFailures, s.disconnects)
+	if len(s.errors) == 0 {
+		fmt.Fprintln(w, "Errors: none")
+		return
+	}
+	codes := make([]string, 0, len(s.errors))
+	for code := range s.errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	fmt.Fprintln(w, "Errors:")
+	for _, code := range codes {
+		fmt.Fprintf(w, "  %s: %d\n", code, s.errors[code])
+	}
+}
+
+// percentile expects ds to be sorted and not empty.
+func percentile(ds []time.Duration, p int) time.Duration {
+	i := (len(ds)*p+99)/100 - 1
+	return ds[max(i, 0)].Round(time.Microsecond)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	cantstop "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/cant_stop"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	_ "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/pig"
+)
+
+// New rooms host Can't Stop until their host picks another game. Games
+// recorded before rooms could host other games are Can't Stop as well.
+const defaultGameName = cantstop.Name
+
+type gameInfo struct {
+	Name     string         `json:"name"`
+	Title    string         `json:"title"`
+	Rulesets []game.Ruleset `json:"rulesets"`
+	Inputs   []string       `json:"inputs"`
+}
+
+func lookupGame(name string) (game.Game, error) {
+	if name == "" {
+		name = defaultGameName
+	}
+	return game.Lookup(name)
+}
+
+func handlerGames(w http.ResponseWriter, r *http.Request) {
+	result := []gameInfo{}
+	for _, g := range game.All() {
+		result = append(result, gameInfo{
+			Name:     g.Name(),
+			Title:    g.Title(),
+			Rulesets: g.Rulesets(),
+			Inputs:   g.Inputs(),
+		})
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
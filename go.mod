@@ -2,4 +2,7 @@ module github.com/kuangyuwu/boardgame-backend-cant-stop
 
 go 1.22.4
 
-require github.com/gorilla/websocket v1.5.3
+require (
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/text v0.22.0
+)
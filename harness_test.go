@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/config"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+// testTimeout is how long a test client waits for a message it expects.
+const testTimeout = 5 * time.Second
+
+// testQuiet is how long a test client waits to be sure that no message is
+// coming.
+const testQuiet = 100 * time.Millisecond
+
+const testOrigin = "http://cant-stop.kuangyuwu.com"
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+	os.Exit(m.Run())
+}
+
+type testServer struct {
+	t     *testing.T
+	lobby *Lobby
+	url   string
+}
+
+// newTestServer serves a lobby with the default config, changed by edit if
+// it is not nil, until the test ends. As every test client connects from the
+// same address and plays as fast as it can, the rate limits are raised and
+// the per-type quotas lifted.
+func newTestServer(t *testing.T, edit func(*config.Config)) *testServer {
+	t.Helper()
+	cfg := config.Default()
+	cfg.UserRateLimit, cfg.UserRateBurst = 10000, 10000
+	cfg.IPRateLimit, cfg.IPRateBurst = 10000, 10000
+	cfg.MessageQuotas = nil
+	if edit != nil {
+		edit(&cfg)
+	}
+	l := initializeLobby(cfg)
+	srv, err := initializeServer(l)
+	if err != nil {
+		t.Fatalf("initializeServer: %s", err)
+	}
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+	return &testServer{
+		t:     t,
+		lobby: l,
+		url:   "ws" + strings.TrimPrefix(ts.URL, "http"),
+	}
+}
+
+// waitFor fails unless cond, which looks at the server's state, holds
+// within testTimeout.
+func (s *testServer) waitFor(what string, cond func() bool) {
+	s.t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("%s: not true after %s", what, testTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type testMessage struct {
+	Seq  uint64          `json:"seq"`
+	Type string          `json:"type"`
+	Body json.RawMessage `json:"body"`
+}
+
+// A testClient is a websocket client speaking JSON. Its messages are read in
+// the background so that the server is never blocked on it.
+type testClient struct {
+	t        *testing.T
+	name     string
+	conn     *websocket.Conn
+	messages chan testMessage
+	closed   chan struct{}
+	closeErr error
+}
+
+func (s *testServer) dial(name string) *testClient {
+	s.t.Helper()
+	header := http.Header{}
+	header.Set("Origin", testOrigin)
+	conn, _, err := websocket.DefaultDialer.Dial(s.url, header)
+	if err != nil {
+		s.t.Fatalf("%s: dial: %s", name, err)
+	}
+	c := &testClient{
+		t:        s.t,
+		name:     name,
+		conn:     conn,
+		messages: make(chan testMessage, 1024),
+		closed:   make(chan struct{}),
+	}
+	s.t.Cleanup(func() { conn.Close() })
+	go c.read()
+	return c
+}
+
+func (c *testClient) read() {
+	defer close(c.closed)
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.closeErr = err
+			return
+		}
+		m := testMessage{}
+		err = json.Unmarshal(data, &m)
+		if err != nil {
+			c.closeErr = err
+			return
+		}
+		c.messages <- m
+	}
+}
+
+// login connects a client and takes username name, which brings it to the
+// lobby. The client speaks the oldest protocol version unless it offers
+// others.
+func (s *testServer) login(name string, versions ...int) *testClient {
+	s.t.Helper()
+	c := s.dial(name)
+	c.send("ready", protocol.ReadyBody{Versions: versions})
+	c.expect("version", "session", "username")
+	c.send("username", protocol.UsernameBody{Username: name})
+	c.expect("prep")
+	return c
+}
+
+func (c *testClient) send(typ string, body any) {
+	c.t.Helper()
+	data, err := json.Marshal(protocol.Data{Type: typ, Body: body})
+	if err != nil {
+		c.t.Fatalf("%s: cannot marshal %s: %s", c.name, typ, err)
+	}
+	err = c.conn.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		c.t.Fatalf("%s: cannot send %s: %s", c.name, typ, err)
+	}
+}
+
+func (c *testClient) next() testMessage {
+	c.t.Helper()
+	select {
+	case m := <-c.messages:
+		return m
+	case <-c.closed:
+		select {
+		case m := <-c.messages:
+			return m
+		default:
+		}
+		c.t.Fatalf("%s: connection closed: %v", c.name, c.closeErr)
+	case <-time.After(testTimeout):
+		c.t.Fatalf("%s: no message after %s", c.name, testTimeout)
+	}
+	return testMessage{}
+}
+
+// expect reads the next messages and fails unless their types are exactly
+// types, in order.
+func (c *testClient) expect(types ...string) []testMessage {
+	c.t.Helper()
+	result := make([]testMessage, len(types))
+	got := make([]string, 0, len(types))
+	for i := range types {
+		result[i] = c.next()
+		got = append(got, result[i].Type)
+		if result[i].Type != types[i] {
+			c.t.Fatalf("%s: got messages %v, want %v (last body %s)", c.name, got, types, result[i].Body)
+		}
+	}
+	return result
+}
+
+// skipTo reads messages until one of type typ, which it returns.
+func (c *testClient) skipTo(typ string) testMessage {
+	c.t.Helper()
+	for {
+		m := c.next()
+		if m.Type == typ {
+			return m
+		}
+	}
+}
+
+// expectNothing fails if a message arrives within testQuiet.
+func (c *testClient) expectNothing() {
+	c.t.Helper()
+	select {
+	case m := <-c.messages:
+		c.t.Fatalf("%s: got unexpected %s %s", c.name, m.Type, m.Body)
+	case <-time.After(testQuiet):
+	}
+}
+
+// expectClosed fails unless the server closes the connection.
+func (c *testClient) expectClosed() {
+	c.t.Helper()
+	for {
+		select {
+		case <-c.messages:
+		case <-c.closed:
+			return
+		case <-time.After(testTimeout):
+			c.t.Fatalf("%s: connection still open after %s", c.name, testTimeout)
+		}
+	}
+}
+
+func decode[T any](t *testing.T, m testMessage) T {
+	t.Helper()
+	var body T
+	err := json.Unmarshal(m.Body, &body)
+	if err != nil {
+		t.Fatalf("cannot decode %s %s: %s", m.Type, m.Body, err)
+	}
+	return body
+}
+
+// expectPrepUpdate reads the next message and fails unless it is a
+// prepUpdate with the given players and readiness.
+func (c *testClient) expectPrepUpdate(isHosting, isReady bool, usernames ...string) protocol.PrepUpdateBody {
+	c.t.Helper()
+	body := decode[protocol.PrepUpdateBody](c.t, c.expect("prepUpdate")[0])
+	if body.IsHosting != isHosting || body.IsReady != isReady || strings.Join(body.Usernames, ",") != strings.Join(usernames, ",") {
+		c.t.Fatalf("%s: got prepUpdate hosting %t ready %t players %v, want hosting %t ready %t players %v",
+			c.name, body.IsHosting, body.IsReady, body.Usernames, isHosting, isReady, usernames)
+	}
+	return body
+}
+
+// expectError reads the next message and fails unless it is an error with
+// the given code.
+func (c *testClient) expectError(code string) protocol.ErrorBody {
+	c.t.Helper()
+	body := decode[protocol.ErrorBody](c.t, c.expect("error")[0])
+	if body.Code != code {
+		c.t.Fatalf("%s: got error %q with code %q, want code %q", c.name, body.Error, body.Code, code)
+	}
+	return body
+}
+
+// newRoom has host create a room and the guests join it, all ready, with
+// the given game and rule set. Every client has read all its messages when
+// it returns.
+func (s *testServer) newRoom(gameName string, ruleset int, host *testClient, guests ...*testClient) string {
+	s.t.Helper()
+	host.send("prepNew", nil)
+	id := host.expectPrepUpdate(true, true, host.name).RoomId
+	host.send("ruleset", protocol.RulesetBody{Game: gameName, Ruleset: ruleset})
+	host.expectPrepUpdate(true, true, host.name)
+
+	names := []string{host.name}
+	for i, g := range guests {
+		g.send("prepJoin", protocol.PrepJoinBody{RoomId: id})
+		names = append(names, g.name)
+		host.expectPrepUpdate(true, false, names...)
+		for _, other := range guests[:i+1] {
+			other.expect("prepUpdate")
+		}
+	}
+	for i, g := range guests {
+		g.send("prepReady", nil)
+		host.expectPrepUpdate(true, i == len(guests)-1, names...)
+		for _, other := range guests {
+			other.expect("prepUpdate")
+		}
+	}
+	return id
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	livenessTimeout = 2 * time.Second
+	livenessRetry   = 10 * time.Millisecond
+)
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+type healthReport struct {
+	Status string         `json:"status"`
+	Checks map[string]any `json:"checks,omitempty"`
+}
+
+type capacityCheck struct {
+	Status  string `json:"status"`
+	Current int    `json:"current"`
+	Max     int    `json:"max"`
+}
+
+type stateCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handlerLiveness fails only when the lobby stops responding, which means
+// the process has to be restarted. It tries the lobby's lock rather than
+// waiting for it, so that probes do not pile up behind a stuck lock.
+func (l *Lobby) handlerLiveness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), livenessTimeout)
+	defer cancel()
+	ticker := time.NewTicker(livenessRetry)
+	defer ticker.Stop()
+
+	for !l.mu.TryLock() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			respondWithJSON(w, http.StatusServiceUnavailable, healthReport{Status: statusFail})
+			return
+		}
+	}
+	l.mu.Unlock()
+	respondWithJSON(w, http.StatusOK, healthReport{Status: statusOK})
+}
+
+// handlerReadiness fails while the server should not be sent new players:
+// when it is full, in maintenance, shutting down, or cannot record games.
+func (l *Lobby) handlerReadiness(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	users := capacityCheck{Current: len(l.users), Max: l.cfg.MaxNumUsersTotal}
+	rooms := capacityCheck{Current: len(l.rooms), Max: l.cfg.MaxNumRooms}
+	maintenance, draining := l.maintenance, l.draining
+	l.mu.Unlock()
+
+	report := healthReport{
+		Status: statusOK,
+		Checks: map[string]any{},
+	}
+	fail := func() string {
+		report.Status = statusFail
+		return statusFail
+	}
+
+	users.Status = statusOK
+	if users.Current >= users.Max {
+		users.Status = fail()
+	}
+	report.Checks["users"] = users
+
+	rooms.Status = statusOK
+	if rooms.Current >= rooms.Max {
+		rooms.Status = fail()
+	}
+	report.Checks["rooms"] = rooms
+
+	state := stateCheck{Status: statusOK}
+	if draining {
+		state = stateCheck{Status: fail(), Error: ErrDraining.Error()}
+	} else if maintenance {
+		state = stateCheck{Status: fail(), Error: ErrMaintenance.Error()}
+	}
+	report.Checks["maintenance"] = state
+
+	store := stateCheck{Status: statusOK}
+	if err := l.store.Check(); err != nil {
+		store = stateCheck{Status: fail(), Error: err.Error()}
+	}
+	report.Checks["store"] = store
+
+	code := http.StatusOK
+	if report.Status != statusOK {
+		code = http.StatusServiceUnavailable
+	}
+	respondWithJSON(w, code, report)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLivenessWhileLobbyIsStuck(t *testing.T) {
+	s := newTestServer(t, nil)
+	probe := func(timeout time.Duration) int {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		req := httptest.NewRequest(http.MethodGet, "/v1/livez", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		s.lobby.handlerLiveness(w, req)
+		return w.Code
+	}
+
+	s.lobby.mu.Lock()
+	for i := 0; i < 3; i++ {
+		if code := probe(50 * time.Millisecond); code != http.StatusServiceUnavailable {
+			t.Errorf("got status %d while the lobby is stuck, want %d", code, http.StatusServiceUnavailable)
+		}
+	}
+	s.lobby.mu.Unlock()
+	if code := probe(time.Second); code != http.StatusOK {
+		t.Errorf("got status %d once the lobby is free, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func (u *User) ping() {
+	u.mu.Lock()
+	conn := u.conn
+	u.mu.Unlock()
+	if conn == nil {
+		return
+	}
+
+	err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Duration(u.lobby.cfg.WriteWait)))
+	if err != nil {
+		u.logger().Warn("ping failed", "err", err)
+		conn.Close()
+	}
+}
+
+func (u *User) touch() {
+	u.mu.Lock()
+	u.lastActive = time.Now()
+	u.mu.Unlock()
+}
+
+func (u *User) idleReason(now time.Time) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.gone || u.conn == nil {
+		return ""
+	}
+	if u.username == "" && now.Sub(u.connectedAt) > time.Duration(u.lobby.cfg.UsernameTimeout) {
+		return "no username chosen"
+	}
+	if u.room == nil && now.Sub(u.lastActive) > time.Duration(u.lobby.cfg.LobbyIdleTimeout) {
+		return "idle without joining a room"
+	}
+	return ""
+}
+
+func (u *User) kick(reason string) {
+	u.mu.Lock()
+	conn := u.conn
+	u.mu.Unlock()
+
+	if conn != nil {
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Duration(u.lobby.cfg.WriteWait)))
+		conn.Close()
+	}
+	u.disconnect()
+}
+
+func (l *Lobby) reapIdleUsers() {
+	ticker := time.NewTicker(time.Duration(l.cfg.ReapInterval))
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		l.reap(now)
+	}
+}
+
+func (l *Lobby) reap(now time.Time) {
+	l.mu.Lock()
+	users := slices.Clone(l.users)
+	l.mu.Unlock()
+
+	for _, u := range users {
+		reason := u.idleReason(now)
+		if reason == "" {
+			continue
+		}
+		u.logger().Info("reaping idle user", "reason", reason)
+		u.kick(reason)
+	}
+
+	l.ipLimiter.Prune(now)
+
+	l.mu.Lock()
+	rooms := slices.Clone(l.rooms)
+	l.mu.Unlock()
+
+	for _, r := range rooms {
+		expired := r.expireReservations(now, time.Duration(l.cfg.ReservationTimeout))
+		if len(expired) == 0 {
+			continue
+		}
+		r.logger().Info("reservations expired", "users", expired)
+		l.deleteRoomIfEmpty(r)
+	}
+}
@@ -1,19 +1,125 @@
 package cantstop
 
-type Data struct {
-	Username string                 `json:"-"`
-	Type     string                 `json:"type"`
-	Body     map[string]interface{} `json:"body"`
+import (
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+type Data = protocol.Data
+
+type ActBody struct {
+	Action []int8 `json:"action"`
+}
+
+type ConfirmBody struct {
+	WillContinue *bool `json:"willContinue"`
+}
+
+type LogBody = game.LogBody
+
+type StartBody struct {
+	Usernames   []string `json:"usernames"`
+	PathLengths []int8   `json:"pathLengths"`
+}
+
+type TurnCountBody struct {
+	TurnCount int16 `json:"turnCount"`
+}
+
+type PlayerBody struct {
+	Username  string `json:"username"`
+	IsPlaying bool   `json:"isPlaying"`
+	Score     int8   `json:"score"`
+}
+
+type MoveCountBody struct {
+	MoveCount int16 `json:"moveCount"`
+}
+
+type ConfirmPromptBody struct {
+	CanUndo bool `json:"canUndo,omitempty"`
+}
+
+type UndoBody struct {
+	Username string `json:"username"`
+	Action   []int8 `json:"action"`
+}
+
+type StandingsBody struct {
+	Standings []Standing `json:"standings"`
+}
+
+type ResultBody struct {
+	Points  []int8   `json:"points"`
+	Options []option `json:"options"`
+	Failed  bool     `json:"failed"`
+}
+
+type WinnerBody = game.WinnerBody
+
+type GameboardBody struct {
+	Seq          uint32    `json:"seq"`
+	Gameboard    [][]space `json:"gameboard"`
+	BlockedPaths []blocked `json:"blockedPaths"`
+}
+
+// GameboardDeltaBody keeps the board it leads to, which is only drawn in full
+// for clients too old to apply the changes.
+type GameboardDeltaBody struct {
+	Seq         uint32   `json:"seq"`
+	Changes     []change `json:"changes"`
+	view        boardView
+	pathLengths []int8
+}
+
+func (b GameboardDeltaBody) Downgrade(version int) (Data, bool) {
+	if version >= 2 {
+		return Data{}, false
+	}
+	return dataGameboard(b.Seq, b.view.gameboard(b.pathLengths), b.view.blockedPaths(b.pathLengths)), true
+}
+
+func init() {
+	protocol.Register(protocol.Inbound, "roll", nil)
+	protocol.Register(protocol.Inbound, "act", ActBody{})
+	protocol.Register(protocol.Inbound, "confirm", ConfirmBody{})
+	protocol.Register(protocol.Inbound, "undo", nil)
+
+	protocol.Register(protocol.Outbound, "start", StartBody{})
+	protocol.Register(protocol.Outbound, "turnCount", TurnCountBody{})
+	protocol.Register(protocol.Outbound, "player", PlayerBody{})
+	protocol.Register(protocol.Outbound, "moveCount", MoveCountBody{})
+	protocol.Register(protocol.Outbound, "roll", nil)
+	protocol.Register(protocol.Outbound, "result", ResultBody{})
+	protocol.Register(protocol.Outbound, "confirm", ConfirmPromptBody{})
+	protocol.Register(protocol.Outbound, "undo", UndoBody{})
+	protocol.Register(protocol.Outbound, "standings", StandingsBody{})
+	protocol.Register(protocol.Outbound, "gameboard", GameboardBody{})
+	protocol.Register(protocol.Outbound, "gameboardDelta", GameboardDeltaBody{})
+}
+
+// emit delivers d to the room. Nothing is delivered while the game replays
+// its journal, since the players already saw those messages.
+func (g GameCantStop) emit(d Data) {
+	if g.replaying {
+		return
+	}
+	g.fromGame <- d
 }
 
 func (g GameCantStop) send(d Data) {
 	d.Username = g.players[g.playing].username
-	g.fromGame <- d
+	g.emit(d)
+}
+
+func (g GameCantStop) sendTo(username string, d Data) {
+	d.Username = username
+	g.emit(d)
 }
 
 func (g GameCantStop) broadcast(d Data) {
 	d.Username = ""
-	g.fromGame <- d
+	g.emit(d)
 }
 
 func (g GameCantStop) announce(content string) {
@@ -21,30 +127,19 @@ func (g GameCantStop) announce(content string) {
 }
 
 func (g GameCantStop) sendExit(username string) {
-	d := Data{
-		Username: username,
-		Type:     "exit",
-		Body:     nil,
-	}
-	g.fromGame <- d
+	g.emit(game.DataExit(username))
 }
 
 func dataLogging(content string) Data {
-	data := Data{
-		Type: "log",
-		Body: map[string]interface{}{
-			"content": content,
-		},
-	}
-	return data
+	return game.DataLog(content)
 }
 
 func dataStart(usernames []string, pathLengths []int8) Data {
 	data := Data{
 		Type: "start",
-		Body: map[string]interface{}{
-			"usernames":   usernames,
-			"pathLengths": pathLengths,
+		Body: StartBody{
+			Usernames:   usernames,
+			PathLengths: pathLengths,
 		},
 	}
 	return data
@@ -53,8 +148,8 @@ func dataStart(usernames []string, pathLengths []int8) Data {
 func dataTurnCount(turnCount int16) Data {
 	data := Data{
 		Type: "turnCount",
-		Body: map[string]interface{}{
-			"turnCount": turnCount,
+		Body: TurnCountBody{
+			TurnCount: turnCount,
 		},
 	}
 	return data
@@ -63,10 +158,10 @@ func dataTurnCount(turnCount int16) Data {
 func dataPlayer(username string, isPlaying bool, score int8) Data {
 	data := Data{
 		Type: "player",
-		Body: map[string]interface{}{
-			"username":  username,
-			"isPlaying": isPlaying,
-			"score":     score,
+		Body: PlayerBody{
+			Username:  username,
+			IsPlaying: isPlaying,
+			Score:     score,
 		},
 	}
 	return data
@@ -75,8 +170,8 @@ func dataPlayer(username string, isPlaying bool, score int8) Data {
 func dataMoveCount(moveCount int16) Data {
 	data := Data{
 		Type: "moveCount",
-		Body: map[string]interface{}{
-			"moveCount": moveCount,
+		Body: MoveCountBody{
+			MoveCount: moveCount,
 		},
 	}
 	return data
@@ -93,33 +188,54 @@ func dataRoll() Data {
 func dataResult(points []int8, options []option, failed bool) Data {
 	data := Data{
 		Type: "result",
-		Body: map[string]interface{}{
-			"points":  points,
-			"options": options,
-			"failed":  failed,
+		Body: ResultBody{
+			Points:  points,
+			Options: options,
+			Failed:  failed,
 		},
 	}
 	return data
 }
 
-func dataConfirm() Data {
+func dataConfirm(canUndo bool) Data {
 	data := Data{
 		Type: "confirm",
-		Body: nil,
+		Body: ConfirmPromptBody{
+			CanUndo: canUndo,
+		},
+	}
+	return data
+}
+
+func dataUndo(username string, action []int8) Data {
+	data := Data{
+		Type: "undo",
+		Body: UndoBody{
+			Username: username,
+			Action:   action,
+		},
 	}
 	return data
 }
 
-func dataWinner(username string) Data {
+func dataStandings(standings []Standing) Data {
 	data := Data{
-		Type: "winner",
-		Body: map[string]interface{}{
-			"winner": username,
+		Type: "standings",
+		Body: StandingsBody{
+			Standings: standings,
 		},
 	}
 	return data
 }
 
+func dataWinner(usernames []string) Data {
+	return game.DataWinner(usernames...)
+}
+
+func dataPaused(paused bool) Data {
+	return game.DataPaused(paused)
+}
+
 type space struct {
 	Colors  []int8 `json:"colors"`
 	HasTemp bool   `json:"hasTemp"`
@@ -130,31 +246,47 @@ type blocked struct {
 	Color int8 `json:"color"`
 }
 
-func dataGameboard(gameboard [][]space, blockedPaths []blocked) Data {
+type changeKind string
+
+const (
+	changeMove  changeKind = "move"
+	changeTemp  changeKind = "temp"
+	changeClaim changeKind = "claim"
+)
+
+type change struct {
+	Kind   changeKind `json:"kind"`
+	Path   int8       `json:"path"`
+	Player int8       `json:"player"`
+	From   int8       `json:"from"`
+	To     int8       `json:"to"`
+}
+
+func dataGameboard(seq uint32, gameboard [][]space, blockedPaths []blocked) Data {
 	data := Data{
 		Type: "gameboard",
-		Body: map[string]interface{}{
-			"gameboard":    gameboard,
-			"blockedPaths": blockedPaths,
+		Body: GameboardBody{
+			Seq:          seq,
+			Gameboard:    gameboard,
+			BlockedPaths: blockedPaths,
 		},
 	}
 	return data
 }
 
-// func dataExit(username string) Data {
-// 	d := Data{
-// 		Username: username,
-// 		Type:     "exit",
-// 		Body:     nil,
-// 	}
-// 	return d
-// }
+func dataGameboardDelta(seq uint32, changes []change, view boardView, pathLengths []int8) Data {
+	data := Data{
+		Type: "gameboardDelta",
+		Body: GameboardDeltaBody{
+			Seq:         seq,
+			Changes:     changes,
+			view:        view,
+			pathLengths: pathLengths,
+		},
+	}
+	return data
+}
 
 func dataTerminate() Data {
-	d := Data{
-		Username: "",
-		Type:     "terminate",
-		Body:     nil,
-	}
-	return d
+	return game.DataTerminate()
 }
@@ -2,7 +2,6 @@ package cantstop
 
 import (
 	"math/rand"
-	"time"
 )
 
 type option struct {
@@ -10,9 +9,8 @@ type option struct {
 	Actions  [][]int8 `json:"actions"`
 }
 
-func rollDices(dices []int8) []int8 {
+func rollDices(rd *rand.Rand, dices []int8) []int8 {
 	result := make([]int8, 0, len(dices))
-	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for _, d := range dices {
 		result = append(result, int8(rd.Intn(int(d)))+1)
 	}
@@ -0,0 +1,460 @@
+package cantstop
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+)
+
+// maxSteps bounds the inputs a test game receives, since a game whose
+// rolls have run out could otherwise go on until maxTurnCount.
+const maxSteps = 2000
+
+// newTestGame starts a game that sends nothing, as when replaying, so that
+// the test can drive it one input at a time. Undo is allowed.
+func newTestGame(t *testing.T, indexRuleSet int, numPlayers int, seed int64, rolls []byte) *GameCantStop {
+	t.Helper()
+	usernames := make([]string, numPlayers)
+	for n := range usernames {
+		usernames[n] = fmt.Sprintf("p%d", n)
+	}
+	g, err := newGame(Setup{
+		IndexRuleSet: indexRuleSet,
+		Usernames:    usernames,
+		Seed:         seed,
+		AllowUndo:    true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("newGame: %s", err)
+	}
+	g.rd = rand.New(game.NewDiceSource(seed, rolls))
+	g.replaying = true
+	g.mu.Lock()
+	g.start()
+	return g
+}
+
+// step feeds the game one input, mostly a legal one picked with choice, and
+// reports whether the game can go on. Some choices pause or unpause it, or
+// take back an action.
+func step(g *GameCantStop, choice byte) bool {
+	if g.terminated || g.ended {
+		return false
+	}
+	d := Data{Username: g.players[g.playing].username}
+	switch {
+	case choice >= 0xe8 && choice < 0xf0:
+		d.Type = game.InputPause
+		if g.paused {
+			d.Type = game.InputUnpause
+		}
+	case choice >= 0xf0:
+		// Something the game must ignore.
+		switch choice % 4 {
+		case 0:
+			d.Username = "nobody"
+			d.Type = "roll"
+		case 1:
+			d.Type = "roll"
+		case 2:
+			d.Type = "act"
+			d.Body = ActBody{Action: []int8{int8(choice % 3), -1, 127}}
+		case 3:
+			d.Type = "confirm"
+			d.Body = ConfirmBody{}
+		}
+	case g.phase == phaseRoll:
+		d.Type = "roll"
+	case g.phase == phaseAct:
+		actions := [][]int8{}
+		for _, o := range g.options {
+			actions = append(actions, o.Actions...)
+		}
+		d.Type = "act"
+		d.Body = ActBody{Action: actions[int(choice)%len(actions)]}
+	case g.phase == phaseConfirm && choice%8 == 1:
+		d.Type = "undo"
+	case g.phase == phaseConfirm:
+		willContinue := choice%4 != 0
+		d.Type = "confirm"
+		d.Body = ConfirmBody{WillContinue: &willContinue}
+	}
+	g.mu.Lock()
+	g.apply(d)
+	return true
+}
+
+// checkInvariants reports the first rule of the game that g breaks.
+func checkInvariants(g *GameCantStop) error {
+	if g.playing < 0 || int(g.playing) >= len(g.players) {
+		return fmt.Errorf("playing is %d with %d players", g.playing, len(g.players))
+	}
+	owners := map[int8]int{}
+	for n, p := range g.players {
+		if len(p.progress) != len(g.pathLengths) {
+			return fmt.Errorf("player %d has %d paths, want %d", n, len(p.progress), len(g.pathLengths))
+		}
+		for i, length := range g.pathLengths {
+			k := p.progress[i]
+			if length == -1 && k != -1 {
+				return fmt.Errorf("player %d has progress %d on missing path %d", n, k, i)
+			}
+			if length != -1 && (k < 0 || k > length) {
+				return fmt.Errorf("player %d has progress %d on path %d of length %d", n, k, i, length)
+			}
+			if k == 0 {
+				owners[int8(i)]++
+			}
+		}
+		if n != int(g.playing) && len(p.temp) != 0 {
+			return fmt.Errorf("player %d has temp markers %v while player %d is playing", n, p.temp, g.playing)
+		}
+	}
+
+	if g.paused && g.ended {
+		return fmt.Errorf("game is paused after it ended")
+	}
+
+	p := g.players[g.playing]
+	if int8(len(p.temp)) > g.numTempPaths {
+		return fmt.Errorf("player %d has %d temp markers, at most %d allowed", g.playing, len(p.temp), g.numTempPaths)
+	}
+	for i, k := range p.temp {
+		if i < 0 || int(i) >= len(g.pathLengths) || g.pathLengths[i] == -1 {
+			return fmt.Errorf("temp marker on missing path %d", i)
+		}
+		if k <= 0 || k > p.progress[i] {
+			return fmt.Errorf("temp marker %d spaces up path %d with %d spaces left", k, i, p.progress[i])
+		}
+		if owner := g.completedBy(i); owner != -1 && owner != g.playing {
+			return fmt.Errorf("temp marker on path %d claimed by player %d", i, owner)
+		}
+	}
+
+	blocked := map[int8]int8{}
+	for _, b := range g.blockedPaths() {
+		blocked[b.Path] = b.Color
+	}
+	for i, count := range owners {
+		if count > 1 {
+			return fmt.Errorf("path %d claimed by %d players", i, count)
+		}
+		if _, ok := blocked[i]; !ok {
+			return fmt.Errorf("claimed path %d is not blocked", i)
+		}
+		if g.isValidPath(i) {
+			return fmt.Errorf("claimed path %d is still open to player %d", i, g.playing)
+		}
+	}
+	for n, p := range g.players {
+		claimed := int8(0)
+		for _, color := range blocked {
+			if color == int8(n) {
+				claimed++
+			}
+		}
+		if p.score() != claimed {
+			return fmt.Errorf("player %d has score %d but claimed %d paths", n, p.score(), claimed)
+		}
+		if p.score() >= g.goal && !g.ended && !g.lastRound {
+			return fmt.Errorf("player %d reached the goal but the game goes on", n)
+		}
+	}
+	if g.lastRound && !g.finishRound {
+		return fmt.Errorf("the round is being finished though the rule is off")
+	}
+	if g.ended && g.finishRound && int(g.playing) != len(g.players)-1 {
+		return fmt.Errorf("game ended with player %d of %d playing", g.playing, len(g.players))
+	}
+	if g.ended {
+		standings := g.standings()
+		for n := 1; n < len(standings); n++ {
+			if standings[n].Claimed > standings[n-1].Claimed || standings[n].Rank < standings[n-1].Rank {
+				return fmt.Errorf("%s ranks after %s in %v", standings[n].Username, standings[n-1].Username, standings)
+			}
+		}
+	}
+
+	if g.phase == phaseAct {
+		for _, o := range g.options {
+			for _, a := range o.Actions {
+				temp := maps.Clone(p.temp)
+				if !g.isValidAction(a) {
+					return fmt.Errorf("offered action %v is not valid", a)
+				}
+				if !maps.Equal(temp, p.temp) {
+					return fmt.Errorf("checking action %v changed temp markers from %v to %v", a, temp, p.temp)
+				}
+			}
+		}
+	}
+
+	// Drawing the board must stay within it.
+	g.gameboard()
+	if blocked := g.lastBoard.blockedPaths(g.pathLengths); !slices.Equal(blocked, g.blockedPaths()) {
+		return fmt.Errorf("last board sent has blocked paths %v, want %v", blocked, g.blockedPaths())
+	}
+	return nil
+}
+
+// playTestGame plays a game with the given inputs, then random ones. With an
+// odd seed, the round is finished once someone reaches the goal.
+func playTestGame(t *testing.T, indexRuleSet int, numPlayers int, seed int64, rolls, choices []byte) {
+	t.Helper()
+	g := newTestGame(t, indexRuleSet, numPlayers, seed, rolls)
+	g.finishRound = seed%2 != 0
+	rd := rand.New(rand.NewSource(seed))
+	for n := 0; n < maxSteps; n++ {
+		err := checkInvariants(g)
+		if err != nil {
+			t.Fatalf("after %d inputs: %s", n, err)
+		}
+		choice := byte(rd.Intn(256))
+		if n < len(choices) {
+			choice = choices[n]
+		}
+		if !step(g, choice) {
+			return
+		}
+	}
+}
+
+func TestGamesKeepInvariants(t *testing.T) {
+	for _, rs := range rulesets {
+		for numPlayers := rs.MinPlayers; numPlayers <= 4; numPlayers++ {
+			for seed := int64(0); seed < 20; seed++ {
+				playTestGame(t, rs.Index, numPlayers, seed, nil, nil)
+			}
+		}
+	}
+}
+
+func TestUndo(t *testing.T) {
+	g := newTestGame(t, 4, 2, 1, nil)
+	step(g, 0)
+	if g.phase != phaseAct {
+		t.Fatalf("got phase %d after rolling, want %d", g.phase, phaseAct)
+	}
+	p := g.players[g.playing]
+	temp := maps.Clone(p.temp)
+	points := slices.Clone(g.points)
+
+	step(g, 0)
+	if g.phase != phaseConfirm || maps.Equal(temp, p.temp) {
+		t.Fatalf("got phase %d and temp markers %v after acting", g.phase, p.temp)
+	}
+	g.mu.Lock()
+	g.apply(Data{Username: p.username, Type: "undo"})
+	if g.phase != phaseAct || !maps.Equal(temp, p.temp) || !slices.Equal(points, g.points) {
+		t.Fatalf("got phase %d, temp markers %v and roll %v after undoing, want %d, %v and %v", g.phase, p.temp, g.points, phaseAct, temp, points)
+	}
+
+	// Only the last action can be taken back, once.
+	g.mu.Lock()
+	g.apply(Data{Username: p.username, Type: "undo"})
+	if g.phase != phaseAct {
+		t.Fatalf("got phase %d after undoing twice, want %d", g.phase, phaseAct)
+	}
+	step(g, 0)
+	step(g, 2)
+	temp = maps.Clone(p.temp)
+	phase := g.phase
+	g.mu.Lock()
+	g.apply(Data{Username: p.username, Type: "undo"})
+	if g.phase != phase || !maps.Equal(temp, p.temp) {
+		t.Fatalf("got phase %d and temp markers %v after undoing a confirmed action, want %d and %v", g.phase, p.temp, phase, temp)
+	}
+}
+
+func TestUndoNotAllowed(t *testing.T) {
+	g := newTestGame(t, 4, 2, 1, nil)
+	g.allowUndo = false
+	step(g, 0)
+	step(g, 0)
+	g.mu.Lock()
+	g.apply(Data{Username: g.players[g.playing].username, Type: "undo"})
+	if g.phase != phaseConfirm {
+		t.Fatalf("got phase %d after undo, want %d", g.phase, phaseConfirm)
+	}
+}
+
+func TestGameEnds(t *testing.T) {
+	for _, rs := range rulesets {
+		g := newTestGame(t, rs.Index, 2, 1, nil)
+		// Never pushing one's luck, someone wins well within maxSteps.
+		for n := 0; n < maxSteps && step(g, 0); n++ {
+		}
+		if !g.ended {
+			t.Errorf("%s: no winner after %d inputs", rs.Name, maxSteps)
+		}
+		for _, p := range g.players {
+			if p.totalMoves == 0 {
+				t.Errorf("%s: %s made no moves in %d turns", rs.Name, p.username, g.turnCount)
+			}
+		}
+	}
+}
+
+func TestFinishRound(t *testing.T) {
+	for _, finishRound := range []bool{false, true} {
+		g := newTestGame(t, 4, 3, 1, nil)
+		g.finishRound = finishRound
+		for n := 0; n < maxSteps && step(g, 0); n++ {
+		}
+		if !g.ended {
+			t.Fatalf("no winner after %d inputs", maxSteps)
+		}
+		if last := int(g.playing) == len(g.players)-1; last != finishRound {
+			t.Errorf("finishing the round %t: game ended with player %d of %d playing", finishRound, g.playing, len(g.players))
+		}
+	}
+}
+
+func TestStandings(t *testing.T) {
+	g := newTestGame(t, 4, 4, 1, nil)
+	for n := range g.players {
+		g.players[n].username = fmt.Sprintf("p%d", n)
+		copy(g.players[n].progress, g.pathLengths)
+	}
+	paths := []int{}
+	for i, length := range g.pathLengths {
+		if length != -1 {
+			paths = append(paths, i)
+		}
+	}
+	// p0 and p1 each claimed a path. p1 climbed further elsewhere, and p2
+	// and p3 climbed as far in as many moves.
+	g.players[0].progress[paths[0]] = 0
+	g.players[0].totalMoves = 10
+	g.players[1].progress[paths[1]] = 0
+	g.players[1].progress[paths[2]]--
+	g.players[1].totalMoves = 20
+	g.players[2].progress[paths[2]]--
+	g.players[2].totalMoves = 5
+	g.players[3].progress[paths[3]]--
+	g.players[3].totalMoves = 5
+
+	tests := []struct {
+		tieBreakers []string
+		want        []string
+		ranks       []int
+	}{
+		{nil, []string{"p1", "p0", "p2", "p3"}, []int{1, 2, 3, 3}},
+		{[]string{"moves"}, []string{"p0", "p1", "p2", "p3"}, []int{1, 2, 3, 3}},
+		{[]string{"progress", "turnOrder"}, []string{"p1", "p0", "p3", "p2"}, []int{1, 2, 3, 4}},
+		{[]string{}, []string{"p1", "p0", "p2", "p3"}, []int{1, 2, 3, 3}},
+	}
+	for _, tt := range tests {
+		g.tieBreakers = tt.tieBreakers
+		standings := g.standings()
+		usernames := make([]string, len(standings))
+		ranks := make([]int, len(standings))
+		for n, s := range standings {
+			usernames[n] = s.Username
+			ranks[n] = s.Rank
+		}
+		if !slices.Equal(usernames, tt.want) || !slices.Equal(ranks, tt.ranks) {
+			t.Errorf("tie-breakers %v: got %v ranked %v, want %v ranked %v", tt.tieBreakers, usernames, ranks, tt.want, tt.ranks)
+		}
+	}
+
+	_, err := newGame(Setup{IndexRuleSet: 4, Usernames: []string{"a", "b"}, TieBreakers: []string{"luck"}})
+	if !errors.Is(err, ErrTieBreaker) {
+		t.Errorf("got error %v for an unknown tie-breaker, want %v", err, ErrTieBreaker)
+	}
+}
+
+func TestTiedWinners(t *testing.T) {
+	g := newTestGame(t, 4, 3, 1, nil)
+	for n := range g.players {
+		g.players[n].username = fmt.Sprintf("p%d", n)
+		copy(g.players[n].progress, g.pathLengths)
+	}
+	paths := []int{}
+	for i, length := range g.pathLengths {
+		if length != -1 {
+			paths = append(paths, i)
+		}
+	}
+	// p0 and p2 each claimed one of the shortest paths in as many moves;
+	// p1 claimed none.
+	g.players[0].progress[paths[0]] = 0
+	g.players[0].totalMoves = 10
+	g.players[1].totalMoves = 10
+	g.players[2].progress[paths[len(paths)-1]] = 0
+	g.players[2].totalMoves = 10
+
+	standings := g.standings()
+	tied := map[string]bool{}
+	for _, s := range standings {
+		tied[s.Username] = s.Tied
+	}
+	if !tied["p0"] || tied["p1"] || !tied["p2"] {
+		t.Errorf("got tied %v, want p0 and p2 tied", tied)
+	}
+	if got := winners(standings); !slices.Equal(got, []string{"p0", "p2"}) {
+		t.Errorf("got winners %v, want [p0 p2]", got)
+	}
+
+	g.tieBreakers = []string{"turnOrder"}
+	if got := winners(g.standings()); !slices.Equal(got, []string{"p2"}) {
+		t.Errorf("got winners %v with turnOrder, want [p2]", got)
+	}
+}
+
+func FuzzGame(f *testing.F) {
+	f.Add(uint8(4), uint8(2), int64(1), []byte{}, []byte{})
+	f.Add(uint8(2), uint8(1), int64(2), []byte{0, 0, 1, 1, 5, 5}, []byte{1, 1, 1, 0})
+	f.Add(uint8(3), uint8(3), int64(3), []byte{5, 5, 5, 5, 5, 5, 5, 5, 5}, []byte{0xf0, 0xf1, 0xf2, 0xf3})
+	f.Add(uint8(5), uint8(4), int64(4), []byte{0, 1, 2, 3, 4, 5}, []byte{7, 3, 0xff, 2})
+	f.Fuzz(func(t *testing.T, indexRuleSet, numPlayers uint8, seed int64, rolls, choices []byte) {
+		rs := rulesets[int(indexRuleSet)%len(rulesets)]
+		playTestGame(t, rs.Index, int(numPlayers)%rs.MaxPlayers+1, seed, rolls, choices)
+	})
+}
+
+func FuzzIsValidAction(f *testing.F) {
+	f.Add(uint8(4), []byte{7, 7, 7}, []byte{7, 7})
+	f.Add(uint8(2), []byte{1, 2}, []byte{3, 3})
+	f.Add(uint8(5), []byte{18, 2, 18}, []byte{18, 18})
+	f.Add(uint8(4), []byte{}, []byte{0xff, 20})
+	f.Fuzz(func(t *testing.T, indexRuleSet uint8, taken, action []byte) {
+		g := newTestGame(t, rulesets[int(indexRuleSet)%len(rulesets)].Index, 2, 0, nil)
+		p := g.players[g.playing]
+		for _, b := range taken {
+			i := int8(int(b) % len(g.pathLengths))
+			if g.isValidPath(i) {
+				p.takeAction(i)
+			}
+		}
+		// The action may name any path, even one off the board.
+		a := make([]int8, len(action))
+		for n, b := range action {
+			a[n] = int8(b)
+		}
+
+		temp := maps.Clone(p.temp)
+		valid := g.isValidAction(a)
+		if !maps.Equal(temp, p.temp) {
+			t.Fatalf("isValidAction(%v) changed temp markers from %v to %v", a, temp, p.temp)
+		}
+		if !valid {
+			return
+		}
+		for _, i := range a {
+			p.takeAction(i)
+		}
+		err := checkInvariants(g)
+		if err != nil {
+			t.Fatalf("after taking valid action %v: %s", a, err)
+		}
+	})
+}
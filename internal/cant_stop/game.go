@@ -1,25 +1,44 @@
 package cantstop
 
 import (
-	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
 )
 
 type GameCantStop struct {
-	mu         *sync.Mutex
-	toGame     chan Data
-	fromGame   chan Data
-	turnCount  int16
-	moveCount  int16
-	playing    int8
-	phase      phase
-	failed     bool
-	terminated bool
-	ended      bool
-	players    []player
+	mu           *sync.Mutex
+	toGame       chan Data
+	fromGame     chan Data
+	indexRuleSet int
+	turnCount    int16
+	moveCount    int16
+	playing      int8
+	phase        phase
+	points       []int8
+	options      []option
+	lastAction   []int8
+	failed       bool
+	lastRound    bool
+	paused       bool
+	terminated   bool
+	ended        bool
+	players      []player
+	lastBoard    boardView
+	boardSeq     uint32
+	seed         int64
+	source       *game.CountingSource
+	rd           *rand.Rand
+	journal      func(Event)
+	replaying    bool
+	allowUndo    bool
+	finishRound  bool
+	tieBreakers  []string
+	startedAt    time.Time
+	log          *slog.Logger
 	RuleSet
 }
 
@@ -31,39 +50,74 @@ const (
 	phaseConfirm phase = 2
 )
 
-func StartGameCantStop(indexRuleSet int, usernames []string) (toGame, fromGame chan Data, err error) {
-	ruleSet, err := getRuleSet(indexRuleSet)
+func StartGameCantStop(setup Setup) (toGame, fromGame chan Data, err error) {
+	g, err := newGame(setup)
 	if err != nil {
 		return nil, nil, err
 	}
+	go g.run()
+	return g.toGame, g.fromGame, nil
+}
+
+func newGame(setup Setup) (*GameCantStop, error) {
+	ruleSet, err := getRuleSet(setup.IndexRuleSet)
+	if err != nil {
+		return nil, err
+	}
+	err = checkTieBreakers(setup.TieBreakers)
+	if err != nil {
+		return nil, err
+	}
 
-	players := make([]player, 0, len(usernames))
-	for _, username := range usernames {
+	players := make([]player, 0, len(setup.Usernames))
+	for _, username := range setup.Usernames {
 		players = append(players, newPlayer(username, ruleSet.pathLengths))
 	}
-	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	source := game.NewCountingSource(setup.Seed, 0)
+	rd := rand.New(source)
 	rd.Shuffle(len(players), func(i, j int) { players[i], players[j] = players[j], players[i] })
 
-	g := GameCantStop{
-		mu:        &sync.Mutex{},
-		toGame:    make(chan Data),
-		fromGame:  make(chan Data),
-		turnCount: 0,
-		playing:   0,
-		moveCount: 0,
-		phase:     phaseRoll,
-		players:   players,
-		RuleSet:   ruleSet,
+	g := &GameCantStop{
+		mu:           &sync.Mutex{},
+		toGame:       make(chan Data),
+		fromGame:     make(chan Data),
+		indexRuleSet: setup.IndexRuleSet,
+		turnCount:    0,
+		playing:      0,
+		moveCount:    0,
+		phase:        phaseRoll,
+		players:      players,
+		seed:         setup.Seed,
+		source:       source,
+		rd:           rd,
+		journal:      setup.Journal,
+		allowUndo:    setup.AllowUndo,
+		finishRound:  setup.FinishRound,
+		tieBreakers:  setup.TieBreakers,
+		log:          loggerOrDefault(setup.Logger),
+		RuleSet:      ruleSet,
 	}
-	go g.run()
-	return g.toGame, g.fromGame, nil
+	if g.startedAt = setup.StartedAt; g.startedAt.IsZero() {
+		g.startedAt = time.Now()
+	}
+	g.lastBoard = g.boardView()
+	return g, nil
 }
 
 func (g *GameCantStop) run() {
 	g.mu.Lock()
+	g.start()
+	g.loop()
+}
+
+func (g *GameCantStop) start() {
+	g.observe(gamesStarted.Inc)
 	g.broadcast(dataStart(g.usernames(), g.pathLengths))
 	g.announce("Game starts!")
 	g.nextTurn()
+}
+
+func (g *GameCantStop) loop() {
 	for {
 		g.mu.Lock()
 
@@ -74,38 +128,96 @@ func (g *GameCantStop) run() {
 
 		data, ok := <-g.toGame
 		if !ok {
-			g.logErrorAndTerminate("channel toGame closed unexpectedly")
+			g.logErrorAndTerminate(reasonChannelClosed, "channel toGame closed unexpectedly")
 			return
 		}
-		if data.Type == "exit" {
-			g.handleExit(data.Username)
+		if req, ok := data.Body.(game.SnapshotRequest); ok && data.Type == "snapshot" {
+			req.Reply <- g.snapshot()
+			g.mu.Unlock()
 			continue
 		}
-		if data.Username != g.players[g.playing].username {
-			logError(fmt.Sprintf("received unexpected message from %s", data.Username))
+		if data.Type == "terminate" {
+			g.logErrorAndTerminate(reasonAdmin, "terminated by an administrator")
 			g.mu.Unlock()
 			continue
 		}
-
-		switch data.Type {
-		case "roll":
-			g.handleRoll()
-		case "act":
-			g.handleAct(data.Body)
-		case "confirm":
-			g.handleConfirm(data.Body)
+		if data.Type == game.InputResync {
+			g.sendState(data.Username)
+			g.mu.Unlock()
+			continue
 		}
+		g.apply(data)
 	}
 }
 
-func logError(errMsg string) {
-	log.Println(errMsg)
+// apply handles a player input. It is called with g.mu held and releases it.
+func (g *GameCantStop) apply(data Data) {
+	switch data.Type {
+	case game.InputExit:
+		g.record(data)
+		g.handleExit(data.Username)
+		return
+	case game.InputPause, game.InputUnpause:
+		g.record(data)
+		g.handlePause(data.Type == game.InputPause)
+		return
+	case game.InputAbort:
+		g.record(data)
+		g.handleAbort()
+		return
+	}
+	if data.Username != g.players[g.playing].username {
+		g.logger().Warn("received message from a player who is not playing", "user", data.Username, "type", data.Type)
+		g.mu.Unlock()
+		return
+	}
+	if g.paused {
+		g.logger().Warn("received message while the game is paused", "user", data.Username, "type", data.Type)
+		g.sendTo(data.Username, dataPaused(true))
+		g.mu.Unlock()
+		return
+	}
+
+	switch data.Type {
+	case "roll":
+		g.record(data)
+		g.handleRoll()
+	case "act":
+		g.record(data)
+		body, _ := data.Body.(ActBody)
+		g.handleAct(body)
+	case "confirm":
+		g.record(data)
+		body, _ := data.Body.(ConfirmBody)
+		g.handleConfirm(body)
+	case "undo":
+		g.record(data)
+		g.handleUndo()
+	default:
+		g.logger().Warn("unsupported message type", "type", data.Type)
+		g.mu.Unlock()
+	}
+}
+
+func loggerOrDefault(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
 }
 
-func (g *GameCantStop) logErrorAndTerminate(errMsg string) {
+func (g GameCantStop) logger() *slog.Logger {
+	return g.log.With("turn", g.turnCount, "move", g.moveCount)
+}
+
+func (g *GameCantStop) logErrorAndTerminate(reason string, errMsg string) {
 	content := "game terminated: " + errMsg
-	log.Println(content)
+	g.logger().Error("game terminated", "reason", reason, "err", errMsg)
 	g.announce(content)
+	if !g.terminated {
+		g.observe(gamesTerminated.With(reason).Inc)
+		g.observeDuration("terminated")
+	}
 	g.terminated = true
 }
 
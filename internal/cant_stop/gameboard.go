@@ -1,12 +1,111 @@
 package cantstop
 
-func (g GameCantStop) broadcastGameboard() {
-	g.broadcast(dataGameboard(g.gameboard(), g.blockedPaths()))
+import (
+	"cmp"
+	"slices"
+)
+
+func (g *GameCantStop) broadcastGameboard() {
+	view := g.boardView()
+	changes := diffBoardViews(g.lastBoard, view)
+	if len(changes) == 0 {
+		return
+	}
+	g.boardSeq++
+	g.lastBoard = view
+	g.broadcast(dataGameboardDelta(g.boardSeq, changes, view, g.pathLengths))
+}
+
+func (g GameCantStop) sendState(username string) {
+	g.sendTo(username, dataStart(g.usernames(), g.pathLengths))
+	g.sendTo(username, dataGameboard(g.boardSeq, g.gameboard(), g.blockedPaths()))
+	g.sendTo(username, dataTurnCount(g.turnCount))
+	for n, p := range g.players {
+		g.sendTo(username, dataPlayer(p.username, int8(n) == g.playing && !g.ended, p.score()))
+	}
+	g.sendTo(username, dataMoveCount(g.moveCount))
+	if g.paused {
+		g.sendTo(username, dataPaused(true))
+	}
+	if g.ended {
+		g.sendTo(username, dataStandings(g.standings()))
+	}
+	if g.ended || username != g.players[g.playing].username {
+		return
+	}
+	switch g.phase {
+	case phaseRoll:
+		g.sendTo(username, dataRoll())
+	case phaseAct:
+		g.sendTo(username, dataResult(g.points, g.options, false))
+	case phaseConfirm:
+		if g.failed {
+			g.sendTo(username, dataResult(g.points, g.options, true))
+		} else {
+			g.sendTo(username, dataConfirm(g.allowUndo && g.lastAction != nil))
+		}
+	}
 }
 
 func (g GameCantStop) gameboard() [][]space {
-	gameboard := [][]space{}
+	return g.boardView().gameboard(g.pathLengths)
+}
+
+func (g GameCantStop) blockedPaths() []blocked {
+	blockedPaths := []blocked{}
 	for i, length := range g.pathLengths {
+		if length == -1 {
+			continue
+		}
+		n := g.completedBy(int8(i))
+		if n != -1 {
+			blockedPaths = append(blockedPaths, blocked{
+				Path:  int8(i),
+				Color: n,
+			})
+		}
+	}
+	return blockedPaths
+}
+
+// boardView records where every marker stands. A space index of -1 means the
+// marker has not entered the path yet (or, for temp markers, is not placed).
+type boardView struct {
+	spaces  [][]int8
+	playing int8
+	temp    map[int8]int8
+	blocked map[int8]int8
+}
+
+func (g GameCantStop) boardView() boardView {
+	view := boardView{
+		spaces:  make([][]int8, len(g.players)),
+		playing: g.playing,
+		temp:    map[int8]int8{},
+		blocked: map[int8]int8{},
+	}
+	for n, p := range g.players {
+		view.spaces[n] = make([]int8, len(g.pathLengths))
+		for i, length := range g.pathLengths {
+			view.spaces[n][i] = length - p.progress[i] - 1
+		}
+	}
+	if g.playing >= 0 && int(g.playing) < len(g.players) {
+		p := g.players[g.playing]
+		for i, x := range p.temp {
+			view.temp[i] = view.spaces[g.playing][i] + x
+		}
+	}
+	for _, b := range g.blockedPaths() {
+		view.blocked[b.Path] = b.Color
+	}
+	return view
+}
+
+// gameboard draws the board that v records.
+func (v boardView) gameboard(pathLengths []int8) [][]space {
+	gameboard := [][]space{}
+	for i, length := range pathLengths {
 		gameboard = append(gameboard, []space{})
 		if length == -1 {
 			continue
@@ -18,36 +117,30 @@ func (g GameCantStop) gameboard() [][]space {
 			})
 		}
 	}
-	for n, p := range g.players {
-		for i, length := range g.pathLengths {
+	for n, spaces := range v.spaces {
+		for i, length := range pathLengths {
 			if length == -1 {
 				continue
 			}
-			j := length - p.progress[i] - 1
-			if j != -1 {
+			if j := spaces[i]; j != -1 {
 				gameboard[i][j].Colors = append(gameboard[i][j].Colors, int8(n))
 			}
 		}
 	}
-	p := g.players[g.playing]
-	for i, x := range p.temp {
-		j := g.pathLengths[i] - p.progress[i] - 1
-		for k := int8(1); k <= x; k++ {
-			gameboard[i][j+k].Colors = append(gameboard[i][j+k].Colors, g.playing)
-			gameboard[i][j+k].HasTemp = true
+	for i, top := range v.temp {
+		for j := v.spaces[v.playing][i] + 1; j <= top; j++ {
+			gameboard[i][j].Colors = append(gameboard[i][j].Colors, v.playing)
+			gameboard[i][j].HasTemp = true
 		}
 	}
 	return gameboard
 }
 
-func (g GameCantStop) blockedPaths() []blocked {
+// blockedPaths lists the claimed paths that v records, in order.
+func (v boardView) blockedPaths(pathLengths []int8) []blocked {
 	blockedPaths := []blocked{}
-	for i, length := range g.pathLengths {
-		if length == -1 {
-			continue
-		}
-		n := g.completedBy(int8(i))
-		if n != -1 {
+	for i := range pathLengths {
+		if n, ok := v.blocked[int8(i)]; ok {
 			blockedPaths = append(blockedPaths, blocked{
 				Path:  int8(i),
 				Color: n,
@@ -56,3 +149,61 @@ func (g GameCantStop) blockedPaths() []blocked {
 	}
 	return blockedPaths
 }
+
+func diffBoardViews(old, new boardView) []change {
+	changes := []change{}
+	for n := range new.spaces {
+		for i := range new.spaces[n] {
+			if old.spaces[n][i] != new.spaces[n][i] {
+				changes = append(changes, change{
+					Kind:   changeMove,
+					Path:   int8(i),
+					Player: int8(n),
+					From:   old.spaces[n][i],
+					To:     new.spaces[n][i],
+				})
+			}
+		}
+	}
+	for i, from := range old.temp {
+		if _, ok := new.temp[i]; !ok || old.playing != new.playing {
+			changes = append(changes, change{
+				Kind:   changeTemp,
+				Path:   i,
+				Player: old.playing,
+				From:   from,
+				To:     -1,
+			})
+		}
+	}
+	for i, to := range new.temp {
+		from, ok := old.temp[i]
+		if !ok || old.playing != new.playing {
+			from = -1
+		}
+		if from != to {
+			changes = append(changes, change{
+				Kind:   changeTemp,
+				Path:   i,
+				Player: new.playing,
+				From:   from,
+				To:     to,
+			})
+		}
+	}
+	for i, n := range new.blocked {
+		if _, ok := old.blocked[i]; !ok {
+			changes = append(changes, change{
+				Kind:   changeClaim,
+				Path:   i,
+				Player: n,
+				From:   -1,
+				To:     -1,
+			})
+		}
+	}
+	slices.SortStableFunc(changes, func(a, b change) int {
+		return cmp.Or(cmp.Compare(a.Kind, b.Kind), cmp.Compare(a.Path, b.Path), cmp.Compare(a.Player, b.Player))
+	})
+	return changes
+}
@@ -2,6 +2,7 @@ package cantstop
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -13,7 +14,7 @@ const (
 
 func (g *GameCantStop) nextTurn() {
 	if g.turnCount == maxTurnCount {
-		g.logErrorAndTerminate("max turn count reached")
+		g.logErrorAndTerminate(reasonMaxTurns, "max turn count reached")
 	}
 	g.turnCount++
 	g.playing = -1
@@ -22,6 +23,11 @@ func (g *GameCantStop) nextTurn() {
 }
 
 func (g *GameCantStop) nextPlayer() {
+	if g.lastRound && int(g.playing) == len(g.players)-1 {
+		g.end()
+		g.mu.Unlock()
+		return
+	}
 	g.playing++
 	if int(g.playing) == len(g.players) {
 		g.nextTurn()
@@ -37,7 +43,7 @@ func (g *GameCantStop) nextPlayer() {
 
 func (g *GameCantStop) nextMove() {
 	if g.moveCount == maxMoveCount {
-		g.logErrorAndTerminate("max move count reached")
+		g.logErrorAndTerminate(reasonMaxMoves, "max move count reached")
 	}
 	g.moveCount++
 	g.broadcast(dataMoveCount(g.moveCount))
@@ -53,12 +59,14 @@ func (g *GameCantStop) nextMove() {
 func (g *GameCantStop) handleRoll() {
 	defer g.mu.Unlock()
 	if g.phase != phaseRoll {
-		logError(fmt.Sprintf("unexpected roll message in phase %d", g.phase))
+		g.logger().Warn("unexpected message in this phase", "type", "roll", "phase", g.phase)
 		return
 	}
-	points := rollDices(g.dices)
+	points := rollDices(g.rd, g.dices)
+	g.observe(rolls.Inc)
 	p := g.players[g.playing]
 	g.announce(fmt.Sprintf("Player %s rolled %s", p.username, numsToString(points)))
+	g.logger().Debug("rolled", "user", p.username, "points", points)
 	groupings := pointsToGroupings(points, g.partitions)
 	options := []option{}
 	failed := true
@@ -74,43 +82,73 @@ func (g *GameCantStop) handleRoll() {
 	}
 	if failed {
 		g.announce("No valid actions")
+		g.observe(busts.Inc)
 		g.failed = true
 		g.phase = phaseConfirm
 	} else {
 		g.phase = phaseAct
 	}
+	g.points = points
+	g.options = options
 	g.send(dataResult(points, options, failed))
 }
 
-func (g *GameCantStop) handleAct(body map[string]interface{}) {
+func (g *GameCantStop) handleAct(body ActBody) {
 	defer g.mu.Unlock()
 	if g.phase != phaseAct {
-		logError(fmt.Sprintf("unexpected act message in phase %d", g.phase))
+		g.logger().Warn("unexpected message in this phase", "type", "act", "phase", g.phase)
+		return
+	}
+	action := body.Action
+	if !g.isOffered(action) {
+		g.logger().Warn("action was not offered", "action", action)
 		return
 	}
 	p := g.players[g.playing]
-	action := []int8{}
-	for _, num := range body["action"].([]interface{}) {
-		i, ok := num.(float64)
-		if !ok {
-			logError("invalid act message")
-			return
-		}
-		p.takeAction(int8(i))
-		action = append(action, int8(i))
+	for _, i := range action {
+		p.takeAction(i)
 	}
+	g.lastAction = action
 	g.broadcastGameboard()
 	g.announce(fmt.Sprintf("Player %s advanced %s", p.username, numsToString(action)))
+	g.logger().Debug("advanced", "user", p.username, "action", action)
 	g.phase = phaseConfirm
-	g.send(dataConfirm())
+	g.send(dataConfirm(g.allowUndo))
 }
 
-func (g *GameCantStop) handleConfirm(body map[string]interface{}) {
-	if g.phase != phaseConfirm {
-		logError(fmt.Sprintf("unexpected confirm message in phase %d", g.phase))
+// handleUndo takes back the action just taken and offers the same options
+// for the same roll again.
+func (g *GameCantStop) handleUndo() {
+	defer g.mu.Unlock()
+	if !g.allowUndo {
+		g.logger().Warn("undo is not allowed in this game")
+		return
+	}
+	if g.phase != phaseConfirm || g.failed || g.lastAction == nil {
+		g.logger().Warn("nothing to undo", "phase", g.phase)
 		return
 	}
 	p := g.players[g.playing]
+	for k := len(g.lastAction) - 1; k >= 0; k-- {
+		p.undoAction(g.lastAction[k])
+	}
+	action := g.lastAction
+	g.lastAction = nil
+	g.broadcastGameboard()
+	g.broadcast(dataUndo(p.username, action))
+	g.announce(fmt.Sprintf("Player %s took back advancing %s", p.username, numsToString(action)))
+	g.logger().Debug("undid action", "user", p.username, "action", action)
+	g.phase = phaseAct
+	g.send(dataResult(g.points, g.options, false))
+}
+
+func (g *GameCantStop) handleConfirm(body ConfirmBody) {
+	if g.phase != phaseConfirm {
+		g.logger().Warn("unexpected message in this phase", "type", "confirm", "phase", g.phase)
+		g.mu.Unlock()
+		return
+	}
+	p := &g.players[g.playing]
 	if g.failed {
 		p.resetTemp()
 		p.addMoves(g.moveCount)
@@ -119,12 +157,13 @@ func (g *GameCantStop) handleConfirm(body map[string]interface{}) {
 		g.nextPlayer()
 		return
 	}
-	willContinue, ok := body["willContinue"].(bool)
-	if !ok {
-		logError("invalid confirm message")
+	if body.WillContinue == nil {
+		g.logger().Warn("confirm message without a decision")
+		g.mu.Unlock()
 		return
 	}
-	if willContinue {
+	g.lastAction = nil
+	if *body.WillContinue {
 		g.phase = phaseRoll
 		g.nextMove()
 	} else {
@@ -134,11 +173,14 @@ func (g *GameCantStop) handleConfirm(body map[string]interface{}) {
 		g.broadcastGameboard()
 		g.broadcast(dataPlayer(p.username, false, p.score()))
 		g.announce(fmt.Sprintf("Player %s ended their turn", p.username))
-		if g.isWinner(p) {
-			g.broadcast(dataWinner(p.username))
-			g.ended = true
-			g.mu.Unlock()
-			return
+		if p.score() >= g.goal && !g.lastRound {
+			if !g.finishRound {
+				g.end()
+				g.mu.Unlock()
+				return
+			}
+			g.lastRound = true
+			g.announce(fmt.Sprintf("Player %s reached the goal; the round will be finished", p.username))
 		}
 		g.nextPlayer()
 	}
@@ -147,7 +189,7 @@ func (g *GameCantStop) handleConfirm(body map[string]interface{}) {
 func (g *GameCantStop) handleExit(username string) {
 	defer g.mu.Unlock()
 	if !g.ended {
-		g.logErrorAndTerminate(fmt.Sprintf("player %s exited unexpectedly", username))
+		g.logErrorAndTerminate(reasonPlayerExit, fmt.Sprintf("player %s exited unexpectedly", username))
 	}
 	for n, p := range g.players {
 		if p.username == username {
@@ -157,6 +199,35 @@ func (g *GameCantStop) handleExit(username string) {
 	g.sendExit(username)
 }
 
+func (g *GameCantStop) handlePause(paused bool) {
+	defer g.mu.Unlock()
+	if g.ended || g.paused == paused {
+		g.logger().Warn("unexpected pause", "paused", paused, "ended", g.ended)
+		return
+	}
+	g.paused = paused
+	g.broadcast(dataPaused(paused))
+	if paused {
+		g.announce("Game paused")
+	} else {
+		g.announce("Game resumed")
+	}
+	g.logger().Info("game paused", "paused", paused)
+}
+
+func (g *GameCantStop) handleAbort() {
+	defer g.mu.Unlock()
+	if g.ended {
+		g.logger().Warn("cannot abort a game that has ended")
+		return
+	}
+	g.announce("Game aborted by the players")
+	g.logger().Info("game aborted")
+	g.observe(gamesTerminated.With(reasonAborted).Inc)
+	g.observeDuration("aborted")
+	g.terminated = true
+}
+
 func (g GameCantStop) completedBy(i int8) int8 {
 	for n, p := range g.players {
 		if p.progress[i] == 0 {
@@ -167,6 +238,9 @@ func (g GameCantStop) completedBy(i int8) int8 {
 }
 
 func (g GameCantStop) isValidPath(i int8) bool {
+	if i < 0 || int(i) >= len(g.pathLengths) || g.pathLengths[i] == -1 {
+		return false
+	}
 	if g.completedBy(i) != -1 {
 		return false
 	}
@@ -194,8 +268,27 @@ func (g GameCantStop) isValidAction(action []int8) bool {
 	return true
 }
 
-func (g GameCantStop) isWinner(p player) bool {
-	return p.score() >= g.goal
+func (g GameCantStop) isOffered(action []int8) bool {
+	for _, o := range g.options {
+		for _, a := range o.Actions {
+			if slices.Equal(a, action) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// end ranks the players and declares everyone ranked first the winners.
+func (g *GameCantStop) end() {
+	standings := g.standings()
+	winners := winners(standings)
+	g.broadcast(dataStandings(standings))
+	g.broadcast(dataWinner(winners))
+	g.logger().Info("game won", "users", winners)
+	g.ended = true
+	g.observe(gamesFinished.Inc)
+	g.observeDuration("finished")
 }
 
 func numsToString(nums []int8) string {
@@ -0,0 +1,93 @@
+package cantstop
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+)
+
+var ErrGameOver = game.ErrGameOver
+
+type (
+	Setup = game.Setup
+	Event = game.Event
+)
+
+func (g GameCantStop) record(data Data) {
+	if g.replaying || g.journal == nil {
+		return
+	}
+	e := Event{
+		Username: data.Username,
+		Type:     data.Type,
+	}
+	if data.Body != nil {
+		body, err := json.Marshal(data.Body)
+		if err != nil {
+			g.logger().Error("cannot record input", "type", data.Type, "err", err)
+			return
+		}
+		e.Body = body
+	}
+	g.journal(e)
+}
+
+func eventData(e Event) (Data, error) {
+	d := Data{
+		Username: e.Username,
+		Type:     e.Type,
+	}
+	switch e.Type {
+	case "roll", "undo", game.InputExit, game.InputPause, game.InputUnpause, game.InputAbort:
+	case "act":
+		body := ActBody{}
+		err := json.Unmarshal(e.Body, &body)
+		if err != nil {
+			return Data{}, err
+		}
+		d.Body = body
+	case "confirm":
+		body := ConfirmBody{}
+		err := json.Unmarshal(e.Body, &body)
+		if err != nil {
+			return Data{}, err
+		}
+		d.Body = body
+	default:
+		return Data{}, fmt.Errorf("unknown event type %s", e.Type)
+	}
+	return d, nil
+}
+
+// ReplayGameCantStop rebuilds a game by starting it from setup and feeding it
+// the recorded events without sending anything to the players. It returns
+// ErrGameOver if the game had already finished.
+func ReplayGameCantStop(setup Setup, events []Event) (toGame, fromGame chan Data, err error) {
+	g, err := newGame(setup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	g.replaying = true
+	g.mu.Lock()
+	g.start()
+	for n, e := range events {
+		if g.terminated || g.ended {
+			break
+		}
+		data, err := eventData(e)
+		if err != nil {
+			return nil, nil, fmt.Errorf("event %d: %w", n, err)
+		}
+		g.mu.Lock()
+		g.apply(data)
+	}
+	g.replaying = false
+
+	if g.terminated || g.ended || g.allPlayerLeft() {
+		return nil, nil, ErrGameOver
+	}
+	go g.loop()
+	return g.toGame, g.fromGame, nil
+}
@@ -0,0 +1,38 @@
+package cantstop
+
+import (
+	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/metrics"
+)
+
+const (
+	reasonMaxTurns      = "max_turns"
+	reasonMaxMoves      = "max_moves"
+	reasonPlayerExit    = "player_exit"
+	reasonChannelClosed = "channel_closed"
+	reasonAdmin         = "admin"
+	reasonAborted       = "aborted"
+)
+
+var (
+	gamesStarted    = metrics.Default.NewCounter("cantstop_games_started_total", "Games started.")
+	gamesFinished   = metrics.Default.NewCounter("cantstop_games_finished_total", "Games that ended with a winner.")
+	gamesTerminated = metrics.Default.NewCounterVec("cantstop_games_terminated_total", "Games terminated before a winner was found.", "reason")
+	rolls           = metrics.Default.NewCounter("cantstop_rolls_total", "Dice rolls.")
+	busts           = metrics.Default.NewCounter("cantstop_busts_total", "Dice rolls that offered no valid action.")
+	gameDuration    = metrics.Default.NewHistogramVec("cantstop_game_duration_seconds", "Time from the start of a game until it finished or was terminated.", metrics.DurationBuckets, "outcome")
+)
+
+// observe records what happened in the game unless it is being replayed.
+func (g GameCantStop) observe(fn func()) {
+	if !g.replaying {
+		fn()
+	}
+}
+
+func (g GameCantStop) observeDuration(outcome string) {
+	g.observe(func() {
+		gameDuration.With(outcome).Observe(time.Since(g.startedAt).Seconds())
+	})
+}
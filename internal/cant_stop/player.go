@@ -58,3 +58,14 @@ func (p player) score() int8 {
 	}
 	return count
 }
+
+// climbed counts the spaces p has climbed on all paths, claimed or not.
+func (p player) climbed(pathLengths []int8) int {
+	count := 0
+	for i, length := range pathLengths {
+		if length != -1 {
+			count += int(length - p.progress[i])
+		}
+	}
+	return count
+}
@@ -0,0 +1,38 @@
+package cantstop
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+)
+
+const Name = "cantstop"
+
+type cantStop struct{}
+
+func init() {
+	game.Register(cantStop{})
+}
+
+func (cantStop) Name() string             { return Name }
+func (cantStop) Title() string            { return "Can't Stop" }
+func (cantStop) Rulesets() []game.Ruleset { return rulesets }
+func (cantStop) Inputs() []string         { return []string{"roll", "act", "confirm", "undo"} }
+
+func (cantStop) Start(setup Setup) (toGame, fromGame chan Data, err error) {
+	return StartGameCantStop(setup)
+}
+
+func (cantStop) Replay(setup Setup, events []Event) (toGame, fromGame chan Data, err error) {
+	return ReplayGameCantStop(setup, events)
+}
+
+func (cantStop) Restore(snapshot json.RawMessage, journal func(Event), logger *slog.Logger) (toGame, fromGame chan Data, err error) {
+	s := Snapshot{}
+	err = json.Unmarshal(snapshot, &s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return RestoreGameCantStop(s, journal, logger)
+}
@@ -1,6 +1,6 @@
 package cantstop
 
-import "errors"
+import "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
 
 type RuleSet struct {
 	numTempPaths    int8
@@ -11,7 +11,14 @@ type RuleSet struct {
 	actionGenerator func([][]int8, func([]int8) bool) [][]int8
 }
 
-var ErrRuleSetNotFound = errors.New("rule set not found")
+var ErrRuleSetNotFound = game.ErrRulesetNotFound
+
+var rulesets = []game.Ruleset{
+	{Index: 2, Name: "2 dice", MinPlayers: 1, MaxPlayers: 8},
+	{Index: 3, Name: "3 dice", MinPlayers: 1, MaxPlayers: 8},
+	{Index: 4, Name: "4 dice (classic)", MinPlayers: 1, MaxPlayers: 8, Default: true},
+	{Index: 5, Name: "5 dice", MinPlayers: 1, MaxPlayers: 8},
+}
 
 func getRuleSet(i int) (RuleSet, error) {
 	switch i {
@@ -0,0 +1,173 @@
+package cantstop
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+)
+
+type Snapshot struct {
+	IndexRuleSet int              `json:"indexRuleSet"`
+	TurnCount    int16            `json:"turnCount"`
+	MoveCount    int16            `json:"moveCount"`
+	Playing      int8             `json:"playing"`
+	Phase        int8             `json:"phase"`
+	Points       []int8           `json:"points"`
+	Options      []option         `json:"options"`
+	LastAction   []int8           `json:"lastAction,omitempty"`
+	Failed       bool             `json:"failed"`
+	LastRound    bool             `json:"lastRound,omitempty"`
+	Paused       bool             `json:"paused"`
+	AllowUndo    bool             `json:"allowUndo,omitempty"`
+	FinishRound  bool             `json:"finishRound,omitempty"`
+	TieBreakers  []string         `json:"tieBreakers,omitempty"`
+	Ended        bool             `json:"ended"`
+	BoardSeq     uint32           `json:"boardSeq"`
+	Seed         int64            `json:"seed"`
+	Draws        int64            `json:"draws"`
+	StartedAt    time.Time        `json:"startedAt"`
+	Players      []PlayerSnapshot `json:"players"`
+}
+
+type PlayerSnapshot struct {
+	Username   string        `json:"username"`
+	TotalMoves int32         `json:"totalMoves"`
+	Progress   []int8        `json:"progress"`
+	Temp       map[int8]int8 `json:"temp"`
+	Left       bool          `json:"left"`
+}
+
+func (s Snapshot) PhaseName() string {
+	switch phase(s.Phase) {
+	case phaseRoll:
+		return "roll"
+	case phaseAct:
+		return "act"
+	case phaseConfirm:
+		return "confirm"
+	}
+	return "unknown"
+}
+
+func (s Snapshot) Summary() game.Summary {
+	summary := game.Summary{
+		TurnCount: s.TurnCount,
+		MoveCount: s.MoveCount,
+		Phase:     s.PhaseName(),
+		Paused:    s.Paused,
+		Ended:     s.Ended,
+	}
+	if int(s.Playing) < len(s.Players) {
+		summary.Playing = s.Players[s.Playing].Username
+	}
+	return summary
+}
+
+func (g GameCantStop) snapshot() Snapshot {
+	s := Snapshot{
+		IndexRuleSet: g.indexRuleSet,
+		TurnCount:    g.turnCount,
+		MoveCount:    g.moveCount,
+		Playing:      g.playing,
+		Phase:        int8(g.phase),
+		Points:       g.points,
+		Options:      g.options,
+		LastAction:   g.lastAction,
+		Failed:       g.failed,
+		LastRound:    g.lastRound,
+		Paused:       g.paused,
+		AllowUndo:    g.allowUndo,
+		FinishRound:  g.finishRound,
+		TieBreakers:  g.tieBreakers,
+		Ended:        g.ended,
+		BoardSeq:     g.boardSeq,
+		Seed:         g.seed,
+		Draws:        g.source.Draws(),
+		StartedAt:    g.startedAt,
+		Players:      make([]PlayerSnapshot, 0, len(g.players)),
+	}
+	for _, p := range g.players {
+		temp := make(map[int8]int8, len(p.temp))
+		for i, k := range p.temp {
+			temp[i] = k
+		}
+		s.Players = append(s.Players, PlayerSnapshot{
+			Username:   p.username,
+			TotalMoves: p.totalMoves,
+			Progress:   append([]int8{}, p.progress...),
+			Temp:       temp,
+			Left:       p.left,
+		})
+	}
+	return s
+}
+
+// RestoreGameCantStop resumes a game from a snapshot. Unlike a new game it
+// announces nothing; players receive the state when they ask for a resync.
+// The dice continue from where they were, so the journal stays replayable.
+func RestoreGameCantStop(s Snapshot, journal func(Event), logger *slog.Logger) (toGame, fromGame chan Data, err error) {
+	ruleSet, err := getRuleSet(s.IndexRuleSet)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = checkTieBreakers(s.TieBreakers)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(s.Players) == 0 || int(s.Playing) < 0 || int(s.Playing) >= len(s.Players) {
+		return nil, nil, fmt.Errorf("invalid snapshot: player %d of %d is playing", s.Playing, len(s.Players))
+	}
+
+	players := make([]player, 0, len(s.Players))
+	for _, ps := range s.Players {
+		if len(ps.Progress) != len(ruleSet.pathLengths) {
+			return nil, nil, fmt.Errorf("invalid snapshot: progress of %s has %d paths", ps.Username, len(ps.Progress))
+		}
+		p := newPlayer(ps.Username, ruleSet.pathLengths)
+		p.totalMoves = ps.TotalMoves
+		copy(p.progress, ps.Progress)
+		for i, k := range ps.Temp {
+			p.temp[i] = k
+		}
+		p.left = ps.Left
+		players = append(players, p)
+	}
+
+	source := game.NewCountingSource(s.Seed, s.Draws)
+	g := GameCantStop{
+		mu:           &sync.Mutex{},
+		toGame:       make(chan Data),
+		fromGame:     make(chan Data),
+		indexRuleSet: s.IndexRuleSet,
+		turnCount:    s.TurnCount,
+		moveCount:    s.MoveCount,
+		playing:      s.Playing,
+		phase:        phase(s.Phase),
+		points:       s.Points,
+		options:      s.Options,
+		lastAction:   s.LastAction,
+		failed:       s.Failed,
+		lastRound:    s.LastRound,
+		paused:       s.Paused,
+		allowUndo:    s.AllowUndo,
+		finishRound:  s.FinishRound,
+		tieBreakers:  s.TieBreakers,
+		ended:        s.Ended,
+		players:      players,
+		boardSeq:     s.BoardSeq,
+		seed:         s.Seed,
+		source:       source,
+		rd:           rand.New(source),
+		journal:      journal,
+		log:          loggerOrDefault(logger),
+		startedAt:    s.StartedAt,
+		RuleSet:      ruleSet,
+	}
+	g.lastBoard = g.boardView()
+	go g.loop()
+	return g.toGame, g.fromGame, nil
+}
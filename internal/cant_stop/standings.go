@@ -0,0 +1,96 @@
+package cantstop
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrTieBreaker = errors.New("unknown tie-breaker")
+
+// A Standing is a player's place at the end of the game. Players are ranked
+// by the paths they claimed, then by the game's tie-breakers in order;
+// players whom none of them separates share a rank and are marked Tied.
+type Standing struct {
+	Rank       int    `json:"rank"`
+	Tied       bool   `json:"tied,omitempty"`
+	Username   string `json:"username"`
+	Claimed    int8   `json:"claimed"`
+	Progress   int    `json:"progress"`
+	TotalMoves int32  `json:"totalMoves"`
+	seat       int
+}
+
+// tieBreakers compare two players who claimed as many paths, and return a
+// negative number if a ranks before b.
+var tieBreakers = map[string]func(a, b Standing) int{
+	// progress favours the player who climbed more spaces in all.
+	"progress": func(a, b Standing) int { return cmp.Compare(b.Progress, a.Progress) },
+	// moves favours the player who needed fewer moves.
+	"moves": func(a, b Standing) int { return cmp.Compare(a.TotalMoves, b.TotalMoves) },
+	// turnOrder favours the player later in the turn order, who had a turn
+	// less unless the round was finished.
+	"turnOrder": func(a, b Standing) int { return cmp.Compare(b.seat, a.seat) },
+}
+
+var defaultTieBreakers = []string{"progress", "moves"}
+
+func checkTieBreakers(names []string) error {
+	for _, name := range names {
+		if _, ok := tieBreakers[name]; !ok {
+			return fmt.Errorf("%w: %q", ErrTieBreaker, name)
+		}
+	}
+	return nil
+}
+
+func (g GameCantStop) standings() []Standing {
+	names := g.tieBreakers
+	if len(names) == 0 {
+		names = defaultTieBreakers
+	}
+	compare := func(a, b Standing) int {
+		if c := cmp.Compare(b.Claimed, a.Claimed); c != 0 {
+			return c
+		}
+		for _, name := range names {
+			if c := tieBreakers[name](a, b); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+
+	standings := make([]Standing, len(g.players))
+	for n, p := range g.players {
+		standings[n] = Standing{
+			Username:   p.username,
+			Claimed:    p.score(),
+			Progress:   p.climbed(g.pathLengths),
+			TotalMoves: p.totalMoves,
+			seat:       n,
+		}
+	}
+	slices.SortStableFunc(standings, compare)
+	for n := range standings {
+		standings[n].Rank = n + 1
+		if n > 0 && compare(standings[n-1], standings[n]) == 0 {
+			standings[n].Rank = standings[n-1].Rank
+			standings[n].Tied = true
+			standings[n-1].Tied = true
+		}
+	}
+	return standings
+}
+
+// winners returns the players ranked first, in turn order.
+func winners(standings []Standing) []string {
+	usernames := []string{}
+	for _, s := range standings {
+		if s.Rank == 1 {
+			usernames = append(usernames, s.Username)
+		}
+	}
+	return usernames
+}
@@ -0,0 +1,338 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/logging"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/origin"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/ratelimit"
+)
+
+const EnvPrefix = "CANTSTOP_"
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Config holds every server setting. Values are resolved in increasing order
+// of precedence: defaults, config file, environment variables, flags.
+type Config struct {
+	Addr           string   `json:"addr" usage:"http service address"`
+	AllowedOrigins []string `json:"allowedOrigins" usage:"comma-separated origins allowed to connect, e.g. https://*.example.com"`
+	DevMode        bool     `json:"devMode" usage:"also allow loopback origins and requests without an Origin header"`
+
+	LogLevel  string `json:"logLevel" usage:"minimum log level: debug, info, warn or error"`
+	LogFormat string `json:"logFormat" usage:"log output format: text or json"`
+
+	AdminToken string `json:"adminToken" usage:"bearer token for the admin API at /admin/v1, empty to disable it"`
+
+	MaxLenUsername     int `json:"maxLenUsername" usage:"maximum length of a username"`
+	MaxNumRooms        int `json:"maxNumRooms" usage:"maximum number of rooms"`
+	MaxNumUsersTotal   int `json:"maxNumUsersTotal" usage:"maximum number of connected users"`
+	MaxNumUsersPerRoom int `json:"maxNumUsersPerRoom" usage:"maximum number of users in a room"`
+
+	ReservedUsernames []string `json:"reservedUsernames" usage:"comma-separated usernames nobody may take"`
+	BlockedWords      []string `json:"blockedWords" usage:"comma-separated words that may not appear in a username"`
+
+	MaxMessageSize   int      `json:"maxMessageSize" usage:"largest websocket message accepted from a client, in bytes"`
+	UserRateLimit    int      `json:"userRateLimit" usage:"messages per second allowed from a user on average"`
+	UserRateBurst    int      `json:"userRateBurst" usage:"messages a user may send at once"`
+	IPRateLimit      int      `json:"ipRateLimit" usage:"messages per second allowed from an IP address on average"`
+	IPRateBurst      int      `json:"ipRateBurst" usage:"messages an IP address may send at once"`
+	MessageQuotas    []string `json:"messageQuotas" usage:"comma-separated per-type quotas such as prepNew:5/1m"`
+	AbuseStrikes     int      `json:"abuseStrikes" usage:"rate-limited messages per minute tolerated before a temporary ban"`
+	AbuseBanDuration Duration `json:"abuseBanDuration" usage:"how long an IP address is banned for abuse"`
+
+	SendQueueSize    int    `json:"sendQueueSize" usage:"outbound messages buffered per user"`
+	ReplayBufferSize int    `json:"replayBufferSize" usage:"sent messages kept per user for replay"`
+	SlowClientPolicy string `json:"slowClientPolicy" usage:"what to do when a send queue is full: resync or disconnect"`
+
+	WriteWait        Duration `json:"writeWait" usage:"deadline for writing a message"`
+	PongWait         Duration `json:"pongWait" usage:"time allowed to read the next pong"`
+	ResumeGrace      Duration `json:"resumeGrace" usage:"how long a dropped session can be resumed"`
+	UsernameTimeout  Duration `json:"usernameTimeout" usage:"how long a user may stay without a username"`
+	LobbyIdleTimeout Duration `json:"lobbyIdleTimeout" usage:"how long a user may idle without joining a room"`
+	ReapInterval     Duration `json:"reapInterval" usage:"how often idle users are reaped"`
+
+	SnapshotPath       string   `json:"snapshotPath" usage:"file where running games are saved on shutdown, off if empty; it holds session tokens"`
+	ShutdownTimeout    Duration `json:"shutdownTimeout" usage:"time allowed for a graceful shutdown"`
+	ReservationTimeout Duration `json:"reservationTimeout" usage:"how long a restored game waits for a player to return"`
+	GameStorePath      string   `json:"gameStorePath" usage:"append-only file recording running games for crash recovery, off if empty; it holds session tokens"`
+
+	HostDecidesPause bool `json:"hostDecidesPause" usage:"let the host pause and unpause a game without a vote"`
+
+	EnableBinaryProtocol bool `json:"enableBinaryProtocol" usage:"offer the CBOR websocket subprotocol"`
+	EnableSessionResume  bool `json:"enableSessionResume" usage:"let clients resume dropped sessions"`
+	EnableQueueStats     bool `json:"enableQueueStats" usage:"serve send queue statistics, with every username, at /v1/queues"`
+	EnableMetrics        bool `json:"enableMetrics" usage:"serve Prometheus metrics at /metrics"`
+}
+
+func Default() Config {
+	return Config{
+		Addr:                 ":80",
+		AllowedOrigins:       []string{"http://cant-stop.kuangyuwu.com"},
+		LogLevel:             "info",
+		LogFormat:            "text",
+		MaxLenUsername:       20,
+		MaxNumRooms:          20,
+		MaxNumUsersTotal:     10,
+		MaxNumUsersPerRoom:   5,
+		ReservedUsernames:    []string{"admin", "administrator", "moderator", "mod", "system", "server", "root", "cantstop"},
+		BlockedWords:         []string{"fuck", "shit", "cunt", "bitch", "asshole"},
+		MaxMessageSize:       4096,
+		UserRateLimit:        10,
+		UserRateBurst:        20,
+		IPRateLimit:          30,
+		IPRateBurst:          60,
+		MessageQuotas:        []string{"username:10/1m", "prepNew:5/1m", "prepJoin:10/1m", "start:5/1m", "resume:10/1m"},
+		AbuseStrikes:         20,
+		AbuseBanDuration:     Duration(10 * time.Minute),
+		SendQueueSize:        64,
+		ReplayBufferSize:     256,
+		SlowClientPolicy:     "resync",
+		WriteWait:            Duration(10 * time.Second),
+		PongWait:             Duration(60 * time.Second),
+		ResumeGrace:          Duration(30 * time.Second),
+		UsernameTimeout:      Duration(2 * time.Minute),
+		LobbyIdleTimeout:     Duration(15 * time.Minute),
+		ReapInterval:         Duration(30 * time.Second),
+		SnapshotPath:         "",
+		ShutdownTimeout:      Duration(10 * time.Second),
+		ReservationTimeout:   Duration(10 * time.Minute),
+		GameStorePath:        "",
+		HostDecidesPause:     true,
+		EnableBinaryProtocol: true,
+		EnableSessionResume:  true,
+		EnableQueueStats:     false,
+		EnableMetrics:        false,
+	}
+}
+
+func (c Config) PingPeriod() time.Duration {
+	return time.Duration(c.PongWait) * 9 / 10
+}
+
+func (c Config) Validate() error {
+	errs := []error{}
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidConfig}, args...)...))
+		}
+	}
+
+	check(c.Addr != "", "addr must not be empty")
+	check(len(c.AllowedOrigins) > 0 || c.DevMode, "allowedOrigins must not be empty outside dev mode")
+	for _, o := range c.AllowedOrigins {
+		_, err := origin.ParsePattern(o)
+		check(err == nil, "allowedOrigins: %s", err)
+	}
+	_, err := logging.ParseLevel(c.LogLevel)
+	check(err == nil, "logLevel must be debug, info, warn or error, got %q", c.LogLevel)
+	check(c.LogFormat == "text" || c.LogFormat == "json", "logFormat must be text or json, got %q", c.LogFormat)
+	check(c.AdminToken == "" || len(c.AdminToken) >= 16, "adminToken must be at least 16 characters")
+	check(c.MaxLenUsername > 0 && c.MaxLenUsername <= 64, "maxLenUsername must be between 1 and 64")
+	check(c.MaxNumRooms > 0, "maxNumRooms must be positive")
+	check(c.MaxNumUsersTotal > 0, "maxNumUsersTotal must be positive")
+	check(c.MaxNumUsersPerRoom > 1, "maxNumUsersPerRoom must be at least 2")
+	check(c.MaxMessageSize > 0, "maxMessageSize must be positive")
+	check(c.UserRateLimit > 0 && c.UserRateBurst > 0, "userRateLimit and userRateBurst must be positive")
+	check(c.IPRateLimit > 0 && c.IPRateBurst > 0, "ipRateLimit and ipRateBurst must be positive")
+	for _, q := range c.MessageQuotas {
+		_, _, err := ratelimit.ParseQuota(q)
+		check(err == nil, "messageQuotas: %s", err)
+	}
+	check(c.AbuseStrikes > 0, "abuseStrikes must be positive")
+	check(c.AbuseBanDuration > 0, "abuseBanDuration must be positive")
+	check(c.SendQueueSize > 0, "sendQueueSize must be positive")
+	check(c.ReplayBufferSize > 0, "replayBufferSize must be positive")
+	check(c.SlowClientPolicy == "resync" || c.SlowClientPolicy == "disconnect", "slowClientPolicy must be resync or disconnect, got %q", c.SlowClientPolicy)
+	check(c.WriteWait > 0, "writeWait must be positive")
+	check(c.PongWait > 0, "pongWait must be positive")
+	check(c.ResumeGrace > 0, "resumeGrace must be positive")
+	check(c.UsernameTimeout > 0, "usernameTimeout must be positive")
+	check(c.LobbyIdleTimeout > 0, "lobbyIdleTimeout must be positive")
+	check(c.ReapInterval > 0, "reapInterval must be positive")
+	check(c.ShutdownTimeout > 0, "shutdownTimeout must be positive")
+	check(c.ReservationTimeout > 0, "reservationTimeout must be positive")
+	return errors.Join(errs...)
+}
+
+// Load resolves the config from args (without the program name) and the
+// environment, read with lookupenv such as os.LookupEnv. A variable set to
+// the empty string still applies, so that it can turn off a setting whose
+// default is on. The config file is named by -config or CANTSTOP_CONFIG.
+func Load(args []string, lookupenv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+
+	configPath, _ := lookupenv(EnvPrefix + "CONFIG")
+	fs := flag.NewFlagSet("boardgame-backend-cant-stop", flag.ContinueOnError)
+	path := fs.String("config", configPath, "path to a JSON config file")
+	flagValues := map[string]string{}
+	for _, f := range settings(&cfg) {
+		name := f.flagName
+		record := func(s string) error {
+			flagValues[name] = s
+			return nil
+		}
+		if f.value.Kind() == reflect.Bool {
+			fs.BoolFunc(name, f.usage, record)
+		} else {
+			fs.Func(name, f.usage, record)
+		}
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if *path != "" {
+		err = cfg.loadFile(*path)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, f := range settings(&cfg) {
+		if s, ok := lookupenv(f.envName); ok {
+			err = f.set(s)
+			if err != nil {
+				return Config{}, fmt.Errorf("%w: %s: %s", ErrInvalidConfig, f.envName, err)
+			}
+		}
+	}
+
+	for _, f := range settings(&cfg) {
+		if s, ok := flagValues[f.flagName]; ok {
+			err = f.set(s)
+			if err != nil {
+				return Config{}, fmt.Errorf("%w: -%s: %s", ErrInvalidConfig, f.flagName, err)
+			}
+		}
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(c)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidConfig, path, err)
+	}
+	return nil
+}
+
+type setting struct {
+	flagName string
+	envName  string
+	usage    string
+	value    reflect.Value
+}
+
+func settings(c *Config) []setting {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	result := make([]setting, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("json")
+		result = append(result, setting{
+			flagName: kebab(name),
+			envName:  EnvPrefix + strings.ToUpper(strings.ReplaceAll(kebab(name), "-", "_")),
+			usage:    f.Tag.Get("usage"),
+			value:    v.Field(i),
+		})
+	}
+	return result
+}
+
+func (s setting) set(str string) error {
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(str)
+	case int:
+		i, err := strconv.Atoi(str)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(i))
+	case bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(b)
+	case Duration:
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+	case []string:
+		list := []string{}
+		for _, item := range strings.Split(str, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		s.value.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
+}
+
+func kebab(name string) string {
+	b := strings.Builder{}
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		s, ok := env[name]
+		return s, ok
+	}
+}
+
+// writeConfig writes a config file that sets the admin token and both
+// paths, and returns its path.
+func writeConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{
+		"adminToken": "0123456789abcdef",
+		"snapshotPath": "snapshot.json",
+		"gameStorePath": "games.jsonl"
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEmptyEnvDisables(t *testing.T) {
+	cfg, err := Load(nil, lookupIn(map[string]string{
+		EnvPrefix + "CONFIG":          writeConfig(t),
+		EnvPrefix + "ADMIN_TOKEN":     "",
+		EnvPrefix + "SNAPSHOT_PATH":   "",
+		EnvPrefix + "GAME_STORE_PATH": "",
+	}))
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	if cfg.AdminToken != "" || cfg.SnapshotPath != "" || cfg.GameStorePath != "" {
+		t.Errorf("got admin token %q, snapshot path %q and game store path %q, want all empty", cfg.AdminToken, cfg.SnapshotPath, cfg.GameStorePath)
+	}
+}
+
+func TestUnsetEnvKeepsFileValues(t *testing.T) {
+	cfg, err := Load(nil, lookupIn(map[string]string{
+		EnvPrefix + "CONFIG": writeConfig(t),
+	}))
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	if cfg.SnapshotPath != "snapshot.json" || cfg.GameStorePath != "games.jsonl" {
+		t.Errorf("got snapshot path %q and game store path %q, want snapshot.json and games.jsonl", cfg.SnapshotPath, cfg.GameStorePath)
+	}
+}
+
+func TestNothingIsWrittenByDefault(t *testing.T) {
+	cfg, err := Load(nil, lookupIn(nil))
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	if cfg.SnapshotPath != "" || cfg.GameStorePath != "" {
+		t.Errorf("got snapshot path %q and game store path %q by default, want both empty", cfg.SnapshotPath, cfg.GameStorePath)
+	}
+}
+
+func TestFlagsOverrideEnv(t *testing.T) {
+	cfg, err := Load([]string{"-snapshot-path", "flag.json"}, lookupIn(map[string]string{
+		EnvPrefix + "SNAPSHOT_PATH": "",
+	}))
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	if cfg.SnapshotPath != "flag.json" {
+		t.Errorf("got snapshot path %q, want flag.json", cfg.SnapshotPath)
+	}
+}
+
+func TestEmptyEnvForNumberFails(t *testing.T) {
+	_, err := Load(nil, lookupIn(map[string]string{
+		EnvPrefix + "MAX_NUM_ROOMS": "",
+	}))
+	if err == nil {
+		t.Error("got no error for an empty number")
+	}
+}
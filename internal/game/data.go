@@ -0,0 +1,68 @@
+package game
+
+import "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+
+type LogBody struct {
+	Content string `json:"content"`
+}
+
+// WinnerBody names the winner. When several players share first place,
+// Winners lists them all and Winner is the first of them.
+type WinnerBody struct {
+	Winner  string   `json:"winner"`
+	Winners []string `json:"winners"`
+}
+
+type PausedBody struct {
+	Paused bool `json:"paused"`
+}
+
+func init() {
+	protocol.Register(protocol.Inbound, InputExit, nil)
+	protocol.Register(protocol.Inbound, InputResync, nil)
+
+	protocol.Register(protocol.Outbound, "log", LogBody{})
+	protocol.Register(protocol.Outbound, "winner", WinnerBody{})
+	protocol.Register(protocol.Outbound, "paused", PausedBody{})
+}
+
+func DataLog(content string) Data {
+	return Data{
+		Type: "log",
+		Body: LogBody{
+			Content: content,
+		},
+	}
+}
+
+func DataWinner(usernames ...string) Data {
+	return Data{
+		Type: "winner",
+		Body: WinnerBody{
+			Winner:  usernames[0],
+			Winners: usernames,
+		},
+	}
+}
+
+func DataPaused(paused bool) Data {
+	return Data{
+		Type: "paused",
+		Body: PausedBody{
+			Paused: paused,
+		},
+	}
+}
+
+func DataExit(username string) Data {
+	return Data{
+		Username: username,
+		Type:     "exit",
+	}
+}
+
+func DataTerminate() Data {
+	return Data{
+		Type: "terminate",
+	}
+}
@@ -0,0 +1,234 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+type Data = protocol.Data
+
+var (
+	ErrGameNotFound    = errors.New("game not found")
+	ErrRulesetNotFound = errors.New("rule set not found")
+	ErrPlayerCount     = errors.New("wrong number of players")
+	ErrGameOver        = errors.New("the game is over")
+	ErrSnapshotTimeout = errors.New("timed out waiting for game snapshot")
+)
+
+// Every game accepts these inputs besides its own. The room sends exit when
+// a player leaves and resync when a player needs the whole state again. It
+// sends pause, unpause and abort once the players have voted for them.
+//
+// A paused game ignores every input of its own and broadcasts paused
+// whenever it is paused or unpaused. An aborted game terminates without a
+// winner.
+const (
+	InputExit    = "exit"
+	InputResync  = "resync"
+	InputPause   = "pause"
+	InputUnpause = "unpause"
+	InputAbort   = "abort"
+)
+
+// A Game is a kind of game a room can host. A running game is a goroutine
+// that reads player inputs from toGame and writes messages for the players
+// to fromGame. A message with an empty Username is for every player.
+//
+// On fromGame, the types exit and terminate are for the room: exit tells it
+// that the player has left the game, terminate that the game is over. On
+// toGame, the game must also answer a SnapshotRequest with type snapshot and
+// end itself on type terminate.
+type Game interface {
+	// Name identifies the game in the protocol, the game store and snapshots.
+	Name() string
+	Title() string
+	Rulesets() []Ruleset
+	// Inputs lists the message types the game reads from players, apart
+	// from exit and resync.
+	Inputs() []string
+
+	Start(setup Setup) (toGame, fromGame chan Data, err error)
+	// Replay rebuilds a game from its setup and every input it accepted,
+	// without sending anything to the players. It returns ErrGameOver if the
+	// game had already ended.
+	Replay(setup Setup, events []Event) (toGame, fromGame chan Data, err error)
+	// Restore resumes a game from a snapshot encoded as JSON.
+	Restore(snapshot json.RawMessage, journal func(Event), logger *slog.Logger) (toGame, fromGame chan Data, err error)
+}
+
+type Ruleset struct {
+	Index      int    `json:"index"`
+	Name       string `json:"name"`
+	MinPlayers int    `json:"minPlayers"`
+	MaxPlayers int    `json:"maxPlayers"`
+	// Default marks the rule set a room picks along with the game.
+	Default bool `json:"default,omitempty"`
+}
+
+// Setup is everything needed to start a game. Given the same setup and the
+// same inputs, a game always plays out the same way.
+type Setup struct {
+	IndexRuleSet int       `json:"indexRuleSet"`
+	Usernames    []string  `json:"usernames"`
+	Seed         int64     `json:"seed"`
+	StartedAt    time.Time `json:"startedAt"`
+	// AllowUndo lets a player take back their last move before committing to
+	// it, in games that support it.
+	AllowUndo bool `json:"allowUndo,omitempty"`
+	// FinishRound lets everyone finish the round once someone has won, so
+	// that all players have had as many turns.
+	FinishRound bool `json:"finishRound,omitempty"`
+	// TieBreakers orders players with the same result, in games that rank
+	// them. Empty means the game's default.
+	TieBreakers []string `json:"tieBreakers,omitempty"`
+
+	// Journal, if set, is called with every player input the game accepts.
+	Journal func(Event) `json:"-"`
+	// Logger, if set, is used for everything the game logs.
+	Logger *slog.Logger `json:"-"`
+}
+
+// Event is a player input as recorded in the journal.
+type Event struct {
+	Username string          `json:"username"`
+	Type     string          `json:"type"`
+	Body     json.RawMessage `json:"body,omitempty"`
+}
+
+var registry = struct {
+	mu    *sync.RWMutex
+	games map[string]Game
+}{
+	mu:    &sync.RWMutex{},
+	games: map[string]Game{},
+}
+
+// Register makes a game available to rooms. It is meant to be called from
+// the init function of the package implementing the game.
+func Register(g Game) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.games[g.Name()]; ok {
+		panic(fmt.Sprintf("game: %q registered twice", g.Name()))
+	}
+	registry.games[g.Name()] = g
+}
+
+func Lookup(name string) (Game, error) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	g, ok := registry.games[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrGameNotFound, name)
+	}
+	return g, nil
+}
+
+// All returns the registered games sorted by name.
+func All() []Game {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	result := make([]Game, 0, len(registry.games))
+	for _, g := range registry.games {
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result
+}
+
+func FindRuleset(g Game, index int) (Ruleset, error) {
+	for _, rs := range g.Rulesets() {
+		if rs.Index == index {
+			return rs, nil
+		}
+	}
+	return Ruleset{}, ErrRulesetNotFound
+}
+
+// DefaultRuleset returns the rule set of g marked as the default, or its
+// first one.
+func DefaultRuleset(g Game) Ruleset {
+	rulesets := g.Rulesets()
+	for _, rs := range rulesets {
+		if rs.Default {
+			return rs
+		}
+	}
+	return rulesets[0]
+}
+
+// CheckPlayers reports whether the rule set can be played by n players.
+func (rs Ruleset) CheckPlayers(n int) error {
+	if n < rs.MinPlayers || n > rs.MaxPlayers {
+		return fmt.Errorf("%w: %s needs %d to %d players", ErrPlayerCount, rs.Name, rs.MinPlayers, rs.MaxPlayers)
+	}
+	return nil
+}
+
+// Accepts reports whether players of g may send it messages of type typ.
+func Accepts(g Game, typ string) bool {
+	return typ == InputExit || typ == InputResync || slices.Contains(g.Inputs(), typ)
+}
+
+// IsInput reports whether any registered game accepts messages of type typ.
+func IsInput(typ string) bool {
+	for _, g := range All() {
+		if Accepts(g, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// A Snapshot is the complete state of a running game.
+type Snapshot interface {
+	Summary() Summary
+}
+
+// Summary is the part of a game's state that is common to every game.
+type Summary struct {
+	TurnCount int16  `json:"turnCount"`
+	MoveCount int16  `json:"moveCount"`
+	Playing   string `json:"playing"`
+	Phase     string `json:"phase"`
+	Paused    bool   `json:"paused"`
+	Ended     bool   `json:"ended"`
+}
+
+// SnapshotRequest is sent to a game with type snapshot. The game replies
+// with its state on Reply.
+type SnapshotRequest struct {
+	Reply chan Snapshot
+}
+
+// RequestSnapshot asks the game listening on toGame for a copy of its state.
+func RequestSnapshot(toGame chan Data, timeout time.Duration) (Snapshot, error) {
+	req := SnapshotRequest{
+		Reply: make(chan Snapshot, 1),
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case toGame <- Data{Type: "snapshot", Body: req}:
+	case <-timer.C:
+		return nil, ErrSnapshotTimeout
+	}
+	select {
+	case s := <-req.Reply:
+		return s, nil
+	case <-timer.C:
+		return nil, ErrSnapshotTimeout
+	}
+}
@@ -0,0 +1,68 @@
+package game
+
+import "math/rand"
+
+// CountingSource remembers how many values it has produced, so a restored
+// game can fast-forward a fresh source with the same seed to where it was.
+type CountingSource struct {
+	src   rand.Source64
+	draws int64
+}
+
+func NewCountingSource(seed int64, draws int64) *CountingSource {
+	s := &CountingSource{
+		src: rand.NewSource(seed).(rand.Source64),
+	}
+	for s.draws < draws {
+		s.Int63()
+	}
+	return s
+}
+
+func (s *CountingSource) Draws() int64 {
+	return s.draws
+}
+
+func (s *CountingSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *CountingSource) Uint64() uint64 {
+	s.draws++
+	return s.src.Uint64()
+}
+
+func (s *CountingSource) Seed(seed int64) {
+	s.draws = 0
+	s.src.Seed(seed)
+}
+
+// DiceSource lets tests and fuzzers choose the dice: each of its values v
+// makes the next Intn(n) return v modulo n. Once they run out, it goes on
+// like a source seeded with seed.
+type DiceSource struct {
+	values []byte
+	src    rand.Source
+}
+
+func NewDiceSource(seed int64, values []byte) *DiceSource {
+	return &DiceSource{
+		values: values,
+		src:    rand.NewSource(seed),
+	}
+}
+
+func (s *DiceSource) Int63() int64 {
+	if len(s.values) == 0 {
+		return s.src.Int63()
+	}
+	v := s.values[0]
+	s.values = s.values[1:]
+	// For n below 1<<31, Intn(n) reduces the top 31 bits of Int63.
+	return int64(v) << 32
+}
+
+func (s *DiceSource) Seed(seed int64) {
+	s.src.Seed(seed)
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+var ErrInvalidFormat = errors.New("invalid log format")
+
+const redacted = "[REDACTED]"
+
+// Attributes with these keys never reach the output, wherever they appear.
+var redactedKeys = map[string]bool{
+	"password": true,
+	"token":    true,
+	"secret":   true,
+	"session":  true,
+	"chat":     true,
+}
+
+// New returns a logger writing to w at the given level ("debug", "info",
+// "warn" or "error") in the given format ("text" or "json").
+func New(w io.Writer, level string, format string) (*slog.Logger, error) {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{
+		Level:       l,
+		ReplaceAttr: redact,
+	}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("%w %q: must be text or json", ErrInvalidFormat, format)
+	}
+}
+
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	return l, err
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if redactedKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
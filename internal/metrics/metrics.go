@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Default holds the metrics that live for the whole process.
+var Default = NewRegistry()
+
+// DurationBuckets suit things measured in seconds that take up to an hour.
+var DurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+type Registry struct {
+	mu      *sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		mu:      &sync.Mutex{},
+		metrics: []metric{},
+	}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if slices.ContainsFunc(r.metrics, func(o metric) bool { return o.name() == m.name() }) {
+		panic("metrics: duplicate metric " + m.name())
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes every metric in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type desc struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func (d desc) name() string {
+	return d.metricName
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, d.kind)
+}
+
+// family keeps one child per combination of label values.
+type family[T any] struct {
+	desc
+	mu       *sync.Mutex
+	children map[string]*child[T]
+	create   func() *T
+}
+
+type child[T any] struct {
+	values []string
+	value  *T
+}
+
+func newFamily[T any](d desc, create func() *T) *family[T] {
+	return &family[T]{
+		desc:     d,
+		mu:       &sync.Mutex{},
+		children: map[string]*child[T]{},
+		create:   create,
+	}
+}
+
+func (f *family[T]) with(values ...string) *T {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.metricName, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.children[key]
+	if !ok {
+		c = &child[T]{
+			values: slices.Clone(values),
+			value:  f.create(),
+		}
+		f.children[key] = c
+	}
+	return c.value
+}
+
+func (f *family[T]) sorted() []*child[T] {
+	f.mu.Lock()
+	result := make([]*child[T], 0, len(f.children))
+	for _, c := range f.children {
+		result = append(result, c)
+	}
+	f.mu.Unlock()
+
+	slices.SortFunc(result, func(a, b *child[T]) int {
+		return slices.Compare(a.values, b.values)
+	})
+	return result
+}
+
+func (f *family[T]) labelPairs(values []string, extra ...string) string {
+	pairs := []string{}
+	for i, l := range f.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
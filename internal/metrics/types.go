@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a value that only goes up.
+type Counter struct {
+	bits *atomic.Uint64
+}
+
+func newCounter() *Counter {
+	return &Counter{bits: &atomic.Uint64{}}
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	addFloat(c.bits, v)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	bits *atomic.Uint64
+}
+
+func newGauge() *Gauge {
+	return &Gauge{bits: &atomic.Uint64{}}
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	addFloat(g.bits, v)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	mu      *sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		mu:      &sync.Mutex{},
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type CounterVec struct {
+	*family[Counter]
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newFamily(desc{name, help, "counter", labels}, newCounter)}
+	r.register(v)
+	return v
+}
+
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.with(values...)
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, c := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.metricName, v.labelPairs(c.values), formatFloat(c.value.Value()))
+	}
+}
+
+type GaugeVec struct {
+	*family[Gauge]
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newFamily(desc{name, help, "gauge", labels}, newGauge)}
+	r.register(v)
+	return v
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.with(values...)
+}
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, c := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.metricName, v.labelPairs(c.values), formatFloat(c.value.Value()))
+	}
+}
+
+type HistogramVec struct {
+	*family[Histogram]
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	create := func() *Histogram { return newHistogram(buckets) }
+	v := &HistogramVec{newFamily(desc{name, help, "histogram", labels}, create)}
+	r.register(v)
+	return v
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.with(values...)
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, c := range v.sorted() {
+		h := c.value
+		h.mu.Lock()
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.metricName, v.labelPairs(c.values, "le", formatFloat(b)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.metricName, v.labelPairs(c.values, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.metricName, v.labelPairs(c.values), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.metricName, v.labelPairs(c.values), h.count)
+		h.mu.Unlock()
+	}
+}
@@ -0,0 +1,167 @@
+package origin
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const maxTrackedOrigins = 1000
+
+var ErrInvalidPattern = errors.New("invalid origin pattern")
+
+// A Pattern is an origin such as https://cant-stop.example.com, optionally
+// with a leading wildcard label (https://*.example.com) that matches any
+// subdomain but not the domain itself. A bare * matches every origin.
+type Pattern struct {
+	any    bool
+	scheme string
+	host   string
+	suffix string
+	port   string
+}
+
+func ParsePattern(s string) (Pattern, error) {
+	if s == "*" {
+		return Pattern{any: true}, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return Pattern{}, fmt.Errorf("%w %q: %s", ErrInvalidPattern, s, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return Pattern{}, fmt.Errorf("%w %q: scheme and host are required", ErrInvalidPattern, s)
+	}
+	if u.Path != "" && u.Path != "/" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return Pattern{}, fmt.Errorf("%w %q: only scheme, host and port are allowed", ErrInvalidPattern, s)
+	}
+
+	p := Pattern{
+		scheme: strings.ToLower(u.Scheme),
+		port:   u.Port(),
+	}
+	host := strings.ToLower(u.Hostname())
+	if rest, ok := strings.CutPrefix(host, "*."); ok {
+		if rest == "" || strings.Contains(rest, "*") {
+			return Pattern{}, fmt.Errorf("%w %q: bad wildcard", ErrInvalidPattern, s)
+		}
+		p.suffix = "." + rest
+	} else if strings.Contains(host, "*") {
+		return Pattern{}, fmt.Errorf("%w %q: wildcard must be the leftmost label", ErrInvalidPattern, s)
+	} else {
+		p.host = host
+	}
+	return p, nil
+}
+
+func (p Pattern) Match(u *url.URL) bool {
+	if p.any {
+		return true
+	}
+	if strings.ToLower(u.Scheme) != p.scheme || u.Port() != p.port {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if p.suffix != "" {
+		return strings.HasSuffix(host, p.suffix) && len(host) > len(p.suffix)
+	}
+	return host == p.host
+}
+
+type Policy struct {
+	patterns []Pattern
+	devMode  bool
+	mu       *sync.Mutex
+	rejected map[string]int
+	// untracked counts the rejections from origins seen after
+	// maxTrackedOrigins others.
+	untracked int
+}
+
+func NewPolicy(patterns []string, devMode bool) (*Policy, error) {
+	p := &Policy{
+		patterns: make([]Pattern, 0, len(patterns)),
+		devMode:  devMode,
+		mu:       &sync.Mutex{},
+		rejected: map[string]int{},
+	}
+	for _, s := range patterns {
+		pattern, err := ParsePattern(s)
+		if err != nil {
+			return nil, err
+		}
+		p.patterns = append(p.patterns, pattern)
+	}
+	return p, nil
+}
+
+// Allowed reports whether a request with the given Origin header may proceed.
+// In dev mode, requests without an Origin header and from loopback hosts are
+// allowed as well.
+func (p *Policy) Allowed(origin string) bool {
+	if origin == "" {
+		return p.devMode
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if p.devMode && isLoopback(u.Hostname()) {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if pattern.Match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reject records a rejected origin, logging the first rejection and every
+// hundredth after that. Once maxTrackedOrigins origins are tracked, the
+// rejections from any other origin are counted and logged together.
+func (p *Policy) Reject(origin string, remoteAddr string) {
+	p.mu.Lock()
+	count, ok := p.rejected[origin]
+	tracked := ok || len(p.rejected) < maxTrackedOrigins
+	if tracked {
+		count++
+		p.rejected[origin] = count
+	} else {
+		p.untracked++
+		count = p.untracked
+	}
+	p.mu.Unlock()
+
+	if count != 1 && count%100 != 0 {
+		return
+	}
+	if tracked {
+		slog.Warn("rejected request from disallowed origin", "origin", origin, "remote", remoteAddr, "rejections", count)
+	} else {
+		slog.Warn("rejected requests from too many origins to track", "origin", origin, "remote", remoteAddr, "rejections", count)
+	}
+}
+
+func (p *Policy) Rejections() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := make(map[string]int, len(p.rejected))
+	for origin, count := range p.rejected {
+		result[origin] = count
+	}
+	return result
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,49 @@
+package origin
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+)
+
+type countingHandler struct {
+	slog.Handler
+	records *atomic.Int64
+}
+
+func (h countingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h countingHandler) Handle(context.Context, slog.Record) error {
+	h.records.Add(1)
+	return nil
+}
+
+func TestRejectUntrackedOrigins(t *testing.T) {
+	records := &atomic.Int64{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(countingHandler{records: records}))
+
+	p, err := NewPolicy(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxTrackedOrigins; i++ {
+		p.Reject(fmt.Sprintf("https://%d.example.com", i), "")
+	}
+	if records.Load() != maxTrackedOrigins {
+		t.Fatalf("got %d logs for %d origins, want one each", records.Load(), maxTrackedOrigins)
+	}
+	records.Store(0)
+	for i := 0; i < 250; i++ {
+		p.Reject(fmt.Sprintf("https://extra-%d.example.com", i), "")
+	}
+	// The first, the hundredth and the two hundredth are logged.
+	if records.Load() != 3 {
+		t.Errorf("got %d logs for 250 untracked origins, want 3", records.Load())
+	}
+	if len(p.Rejections()) != maxTrackedOrigins {
+		t.Errorf("tracking %d origins, want %d", len(p.Rejections()), maxTrackedOrigins)
+	}
+}
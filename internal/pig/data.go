@@ -0,0 +1,147 @@
+package pig
+
+import (
+	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+type PigStateBody struct {
+	Usernames []string `json:"usernames"`
+	Scores    []int    `json:"scores"`
+	Goal      int      `json:"goal"`
+	Dices     int      `json:"dices"`
+	TurnCount int16    `json:"turnCount"`
+	Playing   string   `json:"playing"`
+	TurnTotal int      `json:"turnTotal"`
+	LastRoll  []int    `json:"lastRoll"`
+	Paused    bool     `json:"paused"`
+	Ended     bool     `json:"ended"`
+}
+
+type Snapshot struct {
+	IndexRuleSet int              `json:"indexRuleSet"`
+	TurnCount    int16            `json:"turnCount"`
+	RollCount    int16            `json:"rollCount"`
+	Playing      int              `json:"playing"`
+	TurnTotal    int              `json:"turnTotal"`
+	LastRoll     []int            `json:"lastRoll"`
+	Paused       bool             `json:"paused"`
+	Ended        bool             `json:"ended"`
+	Seed         int64            `json:"seed"`
+	Draws        int64            `json:"draws"`
+	StartedAt    time.Time        `json:"startedAt"`
+	Players      []PlayerSnapshot `json:"players"`
+}
+
+type PlayerSnapshot struct {
+	Username string `json:"username"`
+	Score    int    `json:"score"`
+	Left     bool   `json:"left"`
+}
+
+func init() {
+	protocol.Register(protocol.Inbound, "roll", nil)
+	protocol.Register(protocol.Inbound, "hold", nil)
+
+	protocol.Register(protocol.Outbound, "pigState", PigStateBody{})
+}
+
+func (s Snapshot) Summary() game.Summary {
+	summary := game.Summary{
+		TurnCount: s.TurnCount,
+		MoveCount: s.RollCount,
+		Phase:     "roll",
+		Paused:    s.Paused,
+		Ended:     s.Ended,
+	}
+	if s.Ended {
+		summary.Phase = "ended"
+	}
+	if s.Playing >= 0 && s.Playing < len(s.Players) {
+		summary.Playing = s.Players[s.Playing].Username
+	}
+	return summary
+}
+
+func (g *gamePig) snapshot() Snapshot {
+	s := Snapshot{
+		IndexRuleSet: g.indexRuleSet,
+		TurnCount:    g.turnCount,
+		RollCount:    g.rollCount,
+		Playing:      g.playing,
+		TurnTotal:    g.turnTotal,
+		LastRoll:     append([]int{}, g.lastRoll...),
+		Paused:       g.paused,
+		Ended:        g.ended,
+		Seed:         g.seed,
+		Draws:        g.source.Draws(),
+		StartedAt:    g.startedAt,
+		Players:      make([]PlayerSnapshot, 0, len(g.players)),
+	}
+	for _, p := range g.players {
+		s.Players = append(s.Players, PlayerSnapshot{
+			Username: p.username,
+			Score:    p.score,
+			Left:     p.left,
+		})
+	}
+	return s
+}
+
+// emit hands d to the room, except during Replay: the players saw the
+// journal play out the first time.
+func (g *gamePig) emit(d Data) {
+	if g.replaying {
+		return
+	}
+	g.fromGame <- d
+}
+
+func (g *gamePig) broadcast(d Data) {
+	d.Username = ""
+	g.emit(d)
+}
+
+func (g *gamePig) announce(content string) {
+	g.broadcast(game.DataLog(content))
+}
+
+func (g *gamePig) broadcastState() {
+	g.broadcast(g.dataState())
+}
+
+func (g *gamePig) sendTo(username string, d Data) {
+	d.Username = username
+	g.emit(d)
+}
+
+func (g *gamePig) sendState(username string) {
+	g.sendTo(username, g.dataState())
+}
+
+func (g *gamePig) dataState() Data {
+	body := PigStateBody{
+		Usernames: make([]string, 0, len(g.players)),
+		Scores:    make([]int, 0, len(g.players)),
+		Goal:      g.rules.goal,
+		Dices:     g.rules.dices,
+		TurnCount: g.turnCount,
+		TurnTotal: g.turnTotal,
+		LastRoll:  g.lastRoll,
+		Paused:    g.paused,
+		Ended:     g.ended,
+	}
+	for _, p := range g.players {
+		body.Usernames = append(body.Usernames, p.username)
+		body.Scores = append(body.Scores, p.score)
+	}
+	if g.playing >= 0 && g.playing < len(g.players) {
+		body.Playing = g.players[g.playing].username
+	}
+	return Data{
+		Type: "pigState",
+		Body: body,
+	}
+}
@@ -0,0 +1,432 @@
+// Package pig implements Pig, a push-your-luck dice game. On their turn a
+// player rolls as often as they like, adding the dice to their turn total,
+// and holds to bank it. Rolling a 1 loses the turn total.
+package pig
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+)
+
+const (
+	Name = "pig"
+
+	maxTurnCount = 1000
+	maxRollCount = 1000
+)
+
+type Data = game.Data
+
+type rules struct {
+	dices int
+	goal  int
+}
+
+var rulesets = []game.Ruleset{
+	{Index: 1, Name: "Pig", MinPlayers: 2, MaxPlayers: 8, Default: true},
+	{Index: 2, Name: "Two-dice Pig", MinPlayers: 2, MaxPlayers: 8},
+}
+
+// With two dice, a single 1 loses the turn total and a pair of 1s also
+// resets the player's score.
+func getRules(i int) (rules, error) {
+	switch i {
+	case 1:
+		return rules{dices: 1, goal: 100}, nil
+	case 2:
+		return rules{dices: 2, goal: 100}, nil
+	default:
+		return rules{}, game.ErrRulesetNotFound
+	}
+}
+
+type pig struct{}
+
+func init() {
+	game.Register(pig{})
+}
+
+func (pig) Name() string             { return Name }
+func (pig) Title() string            { return "Pig" }
+func (pig) Rulesets() []game.Ruleset { return rulesets }
+func (pig) Inputs() []string         { return []string{"roll", "hold"} }
+
+func (pig) Start(setup game.Setup) (toGame, fromGame chan Data, err error) {
+	g, err := newGame(setup)
+	if err != nil {
+		return nil, nil, err
+	}
+	go func() {
+		g.start()
+		g.loop()
+	}()
+	return g.toGame, g.fromGame, nil
+}
+
+func (pig) Replay(setup game.Setup, events []game.Event) (toGame, fromGame chan Data, err error) {
+	g, err := newGame(setup)
+	if err != nil {
+		return nil, nil, err
+	}
+	g.replaying = true
+	g.start()
+	for n, e := range events {
+		if g.over() {
+			break
+		}
+		switch e.Type {
+		case "roll", "hold", game.InputExit, game.InputPause, game.InputUnpause, game.InputAbort:
+		default:
+			return nil, nil, fmt.Errorf("event %d: unknown event type %s", n, e.Type)
+		}
+		g.apply(Data{Username: e.Username, Type: e.Type})
+	}
+	g.replaying = false
+
+	if g.over() {
+		return nil, nil, game.ErrGameOver
+	}
+	go g.loop()
+	return g.toGame, g.fromGame, nil
+}
+
+func (pig) Restore(snapshot json.RawMessage, journal func(game.Event), logger *slog.Logger) (toGame, fromGame chan Data, err error) {
+	s := Snapshot{}
+	err = json.Unmarshal(snapshot, &s)
+	if err != nil {
+		return nil, nil, err
+	}
+	g, err := fromSnapshot(s, journal, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	go g.loop()
+	return g.toGame, g.fromGame, nil
+}
+
+func fromSnapshot(s Snapshot, journal func(game.Event), logger *slog.Logger) (*gamePig, error) {
+	r, err := getRules(s.IndexRuleSet)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Players) == 0 || s.Playing < 0 || s.Playing >= len(s.Players) {
+		return nil, fmt.Errorf("invalid snapshot: player %d of %d is playing", s.Playing, len(s.Players))
+	}
+
+	source := game.NewCountingSource(s.Seed, s.Draws)
+	g := &gamePig{
+		toGame:       make(chan Data),
+		fromGame:     make(chan Data),
+		indexRuleSet: s.IndexRuleSet,
+		rules:        r,
+		turnCount:    s.TurnCount,
+		rollCount:    s.RollCount,
+		playing:      s.Playing,
+		turnTotal:    s.TurnTotal,
+		lastRoll:     s.LastRoll,
+		paused:       s.Paused,
+		ended:        s.Ended,
+		players:      make([]player, 0, len(s.Players)),
+		seed:         s.Seed,
+		source:       source,
+		rd:           rand.New(source),
+		journal:      journal,
+		startedAt:    s.StartedAt,
+		log:          logger,
+	}
+	if g.log == nil {
+		g.log = slog.Default()
+	}
+	for _, p := range s.Players {
+		g.players = append(g.players, player{
+			username: p.Username,
+			score:    p.Score,
+			left:     p.Left,
+		})
+	}
+	return g, nil
+}
+
+type player struct {
+	username string
+	score    int
+	left     bool
+}
+
+type gamePig struct {
+	toGame       chan Data
+	fromGame     chan Data
+	indexRuleSet int
+	rules        rules
+	turnCount    int16
+	rollCount    int16
+	playing      int
+	turnTotal    int
+	lastRoll     []int
+	paused       bool
+	ended        bool
+	terminated   bool
+	players      []player
+	seed         int64
+	source       *game.CountingSource
+	rd           *rand.Rand
+	journal      func(game.Event)
+	replaying    bool
+	startedAt    time.Time
+	log          *slog.Logger
+}
+
+func newGame(setup game.Setup) (*gamePig, error) {
+	r, err := getRules(setup.IndexRuleSet)
+	if err != nil {
+		return nil, err
+	}
+	players := make([]player, 0, len(setup.Usernames))
+	for _, username := range setup.Usernames {
+		players = append(players, player{username: username})
+	}
+	source := game.NewCountingSource(setup.Seed, 0)
+	rd := rand.New(source)
+	rd.Shuffle(len(players), func(i, j int) { players[i], players[j] = players[j], players[i] })
+
+	g := &gamePig{
+		toGame:       make(chan Data),
+		fromGame:     make(chan Data),
+		indexRuleSet: setup.IndexRuleSet,
+		rules:        r,
+		players:      players,
+		seed:         setup.Seed,
+		source:       source,
+		rd:           rd,
+		journal:      setup.Journal,
+		startedAt:    setup.StartedAt,
+		log:          setup.Logger,
+	}
+	if g.startedAt.IsZero() {
+		g.startedAt = time.Now()
+	}
+	if g.log == nil {
+		g.log = slog.Default()
+	}
+	return g, nil
+}
+
+func (g *gamePig) logger() *slog.Logger {
+	return g.log.With("turn", g.turnCount, "roll", g.rollCount)
+}
+
+func (g *gamePig) start() {
+	g.announce("Game starts!")
+	g.nextTurn()
+}
+
+func (g *gamePig) loop() {
+	for {
+		if g.terminated || g.allPlayerLeft() {
+			g.emit(game.DataTerminate())
+			return
+		}
+
+		data, ok := <-g.toGame
+		if !ok {
+			g.terminate("channel toGame closed unexpectedly")
+			continue
+		}
+		switch data.Type {
+		case "snapshot":
+			if req, ok := data.Body.(game.SnapshotRequest); ok {
+				req.Reply <- g.snapshot()
+			}
+		case "terminate":
+			g.terminate("terminated by an administrator")
+		case game.InputResync:
+			g.sendState(data.Username)
+		default:
+			g.apply(data)
+		}
+	}
+}
+
+func (g *gamePig) apply(data Data) {
+	switch data.Type {
+	case game.InputExit:
+		g.record(data)
+		g.handleExit(data.Username)
+		return
+	case game.InputPause, game.InputUnpause:
+		g.record(data)
+		g.handlePause(data.Type == game.InputPause)
+		return
+	case game.InputAbort:
+		g.record(data)
+		g.handleAbort()
+		return
+	}
+	if g.ended || data.Username != g.players[g.playing].username {
+		g.logger().Warn("received message from a player who is not playing", "user", data.Username, "type", data.Type)
+		return
+	}
+	if g.paused {
+		g.logger().Warn("received message while the game is paused", "user", data.Username, "type", data.Type)
+		g.sendTo(data.Username, game.DataPaused(true))
+		return
+	}
+
+	switch data.Type {
+	case "roll":
+		g.record(data)
+		g.handleRoll()
+	case "hold":
+		g.record(data)
+		g.handleHold()
+	default:
+		g.logger().Warn("unsupported message type", "type", data.Type)
+	}
+}
+
+func (g *gamePig) record(data Data) {
+	if g.replaying || g.journal == nil {
+		return
+	}
+	g.journal(game.Event{
+		Username: data.Username,
+		Type:     data.Type,
+	})
+}
+
+func (g *gamePig) nextTurn() {
+	if g.turnCount == maxTurnCount {
+		g.terminate("max turn count reached")
+		return
+	}
+	g.turnCount++
+	g.playing = -1
+	g.nextPlayer()
+}
+
+func (g *gamePig) nextPlayer() {
+	g.playing++
+	if g.playing == len(g.players) {
+		g.nextTurn()
+		return
+	}
+	g.rollCount = 0
+	g.turnTotal = 0
+	g.lastRoll = nil
+	p := g.players[g.playing]
+	if p.left {
+		g.nextPlayer()
+		return
+	}
+	g.announce(fmt.Sprintf("Player %s's turn", p.username))
+	g.broadcastState()
+}
+
+func (g *gamePig) handleRoll() {
+	if g.rollCount == maxRollCount {
+		g.terminate("max roll count reached")
+		return
+	}
+	g.rollCount++
+	roll := make([]int, g.rules.dices)
+	ones := 0
+	for i := range roll {
+		roll[i] = g.rd.Intn(6) + 1
+		if roll[i] == 1 {
+			ones++
+		}
+	}
+	g.lastRoll = roll
+	p := &g.players[g.playing]
+	g.announce(fmt.Sprintf("Player %s rolled %v", p.username, roll))
+
+	switch {
+	case ones == 2:
+		g.announce(fmt.Sprintf("Snake eyes! Player %s loses their score", p.username))
+		p.score = 0
+		g.nextPlayer()
+	case ones == 1:
+		g.announce(fmt.Sprintf("Player %s loses their turn total", p.username))
+		g.nextPlayer()
+	default:
+		for _, k := range roll {
+			g.turnTotal += k
+		}
+		g.broadcastState()
+	}
+}
+
+func (g *gamePig) handleHold() {
+	p := &g.players[g.playing]
+	p.score += g.turnTotal
+	g.announce(fmt.Sprintf("Player %s holds with %d points", p.username, p.score))
+	if p.score >= g.rules.goal {
+		g.ended = true
+		g.broadcastState()
+		g.broadcast(game.DataWinner(p.username))
+		g.logger().Info("game won", "user", p.username)
+		return
+	}
+	g.nextPlayer()
+}
+
+func (g *gamePig) handleExit(username string) {
+	if !g.ended {
+		g.terminate(fmt.Sprintf("player %s exited unexpectedly", username))
+	}
+	for n, p := range g.players {
+		if p.username == username {
+			g.players[n].left = true
+		}
+	}
+	g.emit(game.DataExit(username))
+}
+
+func (g *gamePig) handlePause(paused bool) {
+	if g.ended || g.paused == paused {
+		g.logger().Warn("unexpected pause", "paused", paused, "ended", g.ended)
+		return
+	}
+	g.paused = paused
+	g.broadcast(game.DataPaused(paused))
+	if paused {
+		g.announce("Game paused")
+	} else {
+		g.announce("Game resumed")
+	}
+	g.logger().Info("game paused", "paused", paused)
+}
+
+func (g *gamePig) handleAbort() {
+	if g.ended {
+		g.logger().Warn("cannot abort a game that has ended")
+		return
+	}
+	g.announce("Game aborted by the players")
+	g.logger().Info("game aborted")
+	g.terminated = true
+}
+
+func (g *gamePig) terminate(errMsg string) {
+	g.logger().Error("game terminated", "err", errMsg)
+	g.announce("game terminated: " + errMsg)
+	g.terminated = true
+}
+
+func (g *gamePig) over() bool {
+	return g.terminated || g.ended || g.allPlayerLeft()
+}
+
+func (g *gamePig) allPlayerLeft() bool {
+	for _, p := range g.players {
+		if !p.left {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package pig
+
+import (
+	"io"
+	"log/slog"
+	"math/rand"
+	"testing"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+)
+
+// gameAt restores a game between p0 and p1 with the given scores, on p0's
+// first roll of a turn. Its dice come up as listed in dice, and it sends
+// nothing, as when replaying.
+func gameAt(t *testing.T, indexRuleSet int, scores [2]int, dice ...int) *gamePig {
+	t.Helper()
+	g, err := fromSnapshot(Snapshot{
+		IndexRuleSet: indexRuleSet,
+		TurnCount:    1,
+		Players: []PlayerSnapshot{
+			{Username: "p0", Score: scores[0]},
+			{Username: "p1", Score: scores[1]},
+		},
+	}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("fromSnapshot: %s", err)
+	}
+	values := make([]byte, len(dice))
+	for n, k := range dice {
+		values[n] = byte(k - 1)
+	}
+	g.rd = rand.New(game.NewDiceSource(1, values))
+	g.replaying = true
+	return g
+}
+
+func (g *gamePig) play(username string, typ string) {
+	g.apply(Data{Username: username, Type: typ})
+}
+
+func TestHold(t *testing.T) {
+	g := gameAt(t, 1, [2]int{0, 0}, 6, 4)
+
+	g.play("p0", "roll")
+	g.play("p0", "roll")
+	if g.turnTotal != 10 {
+		t.Fatalf("turn total is %d after rolling 6 and 4, want 10", g.turnTotal)
+	}
+	g.play("p0", "hold")
+	if g.players[0].score != 10 {
+		t.Errorf("p0 has %d points after holding 10, want 10", g.players[0].score)
+	}
+	if g.playing != 1 || g.turnTotal != 0 {
+		t.Errorf("player %d has turn total %d after p0 held, want p1 with 0", g.playing, g.turnTotal)
+	}
+}
+
+func TestOutOfTurn(t *testing.T) {
+	g := gameAt(t, 1, [2]int{0, 0}, 6)
+
+	g.play("p1", "roll")
+	g.play("p1", "hold")
+	if g.rollCount != 0 || g.playing != 0 {
+		t.Errorf("p1 rolled %d times and player %d is playing, want p0 with no rolls", g.rollCount, g.playing)
+	}
+}
+
+func TestBust(t *testing.T) {
+	g := gameAt(t, 1, [2]int{20, 0}, 5, 1)
+
+	g.play("p0", "roll")
+	g.play("p0", "roll")
+	if g.players[0].score != 20 {
+		t.Errorf("p0 has %d points after rolling a 1, want 20", g.players[0].score)
+	}
+	if g.playing != 1 || g.turnTotal != 0 {
+		t.Errorf("player %d has turn total %d after p0 rolled a 1, want p1 with 0", g.playing, g.turnTotal)
+	}
+}
+
+func TestSnakeEyes(t *testing.T) {
+	tests := []struct {
+		dice  []int
+		score int
+	}{
+		{[]int{3, 1}, 20},
+		{[]int{1, 1}, 0},
+	}
+	for _, tt := range tests {
+		g := gameAt(t, 2, [2]int{20, 0}, tt.dice...)
+		g.play("p0", "roll")
+		if g.players[0].score != tt.score || g.playing != 1 {
+			t.Errorf("rolling %v: p0 has %d points and player %d is playing, want %d and p1", tt.dice, g.players[0].score, g.playing, tt.score)
+		}
+	}
+}
+
+func TestWin(t *testing.T) {
+	g := gameAt(t, 1, [2]int{95, 99}, 6)
+
+	g.play("p0", "roll")
+	g.play("p0", "hold")
+	if !g.ended || !g.over() {
+		t.Fatalf("game has not ended after p0 reached %d", g.players[0].score)
+	}
+	if g.playing != 0 {
+		t.Errorf("player %d is playing after p0 won", g.playing)
+	}
+
+	g.play("p0", "roll")
+	if g.rollCount != 1 {
+		t.Errorf("roll count is %d after a roll once the game ended, want 1", g.rollCount)
+	}
+}
@@ -0,0 +1,221 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCBORValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		cbor  []byte
+	}{
+		{"zero", json.Number("0"), []byte{0x00}},
+		{"small", json.Number("23"), []byte{0x17}},
+		{"one byte", json.Number("24"), []byte{0x18, 0x18}},
+		{"two bytes", json.Number("1000"), []byte{0x19, 0x03, 0xe8}},
+		{"four bytes", json.Number("1000000"), []byte{0x1a, 0x00, 0x0f, 0x42, 0x40}},
+		{"eight bytes", json.Number("1000000000000"), []byte{0x1b, 0x00, 0x00, 0x00, 0xe8, 0xd4, 0xa5, 0x10, 0x00}},
+		{"negative", json.Number("-1"), []byte{0x20}},
+		{"negative two bytes", json.Number("-1000"), []byte{0x39, 0x03, 0xe7}},
+		{"min int64", json.Number("-9223372036854775808"), []byte{0x3b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"float32", json.Number("1.5"), []byte{0xfa, 0x3f, 0xc0, 0x00, 0x00}},
+		{"float64", json.Number("1.1"), []byte{0xfb, 0x3f, 0xf1, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+		{"false", false, []byte{0xf4}},
+		{"true", true, []byte{0xf5}},
+		{"null", nil, []byte{0xf6}},
+		{"text", "é", []byte{0x62, 0xc3, 0xa9}},
+		{"array", []any{json.Number("1"), "a"}, []byte{0x82, 0x01, 0x61, 0x61}},
+		{"map with sorted keys", map[string]any{"b": true, "a": nil}, []byte{0xa2, 0x61, 0x61, 0xf6, 0x61, 0x62, 0xf5}},
+	}
+	for _, tt := range tests {
+		buf := &bytes.Buffer{}
+		err := encodeCBOR(buf, tt.value)
+		if err != nil {
+			t.Errorf("%s: encode: %s", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), tt.cbor) {
+			t.Errorf("%s: encoded as % x, want % x", tt.name, buf.Bytes(), tt.cbor)
+		}
+
+		got, err := decodeCBOR(tt.cbor)
+		if err != nil {
+			t.Errorf("%s: decode: %s", tt.name, err)
+			continue
+		}
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(tt.value)
+		if !bytes.Equal(gotJSON, wantJSON) {
+			t.Errorf("%s: decoded as %s, want %s", tt.name, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestCBORDecodesOtherEncodings(t *testing.T) {
+	tests := []struct {
+		name string
+		cbor []byte
+		want any
+	}{
+		{"half float", []byte{0xf9, 0x3e, 0x00}, 1.5},
+		{"negative half float", []byte{0xf9, 0xc4, 0x00}, -4.0},
+		{"subnormal half float", []byte{0xf9, 0x00, 0x01}, math.Ldexp(1, -24)},
+		{"half float infinity", []byte{0xf9, 0x7c, 0x00}, math.Inf(1)},
+		{"undefined", []byte{0xf7}, nil},
+		{"tagged", []byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, int64(1363896240)},
+		{"byte string", []byte{0x42, 0x01, 0x02}, []byte{0x01, 0x02}},
+		{"uint in a long head", []byte{0x1b, 0, 0, 0, 0, 0, 0, 0, 0x05}, int64(5)},
+	}
+	for _, tt := range tests {
+		got, err := decodeCBOR(tt.cbor)
+		if err != nil {
+			t.Errorf("%s: decode: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decoded as %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+
+	nan, err := decodeCBOR([]byte{0xf9, 0x7e, 0x00})
+	if f, ok := nan.(float64); err != nil || !ok || !math.IsNaN(f) {
+		t.Errorf("half float NaN: decoded as %#v, %v", nan, err)
+	}
+}
+
+func TestCBORRejectsMalformedInput(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x81}, cborMaxDepth+1)
+	deep = append(deep, 0x00)
+	deepTags := bytes.Repeat([]byte{0xc1}, cborMaxDepth+1)
+	deepTags = append(deepTags, 0x00)
+
+	tests := []struct {
+		name string
+		cbor []byte
+		err  string
+	}{
+		{"empty", nil, "unexpected end"},
+		{"truncated head", []byte{0x19, 0x01}, "unexpected end"},
+		{"truncated text", []byte{0x63, 'a', 'b'}, "unexpected end"},
+		{"truncated array", []byte{0x83, 0x01, 0x02}, "unexpected end"},
+		{"truncated map", []byte{0xa1, 0x61, 'a'}, "unexpected end"},
+		{"truncated float", []byte{0xfb, 0x3f, 0xf1}, "unexpected end"},
+		{"trailing data", []byte{0x01, 0x02}, "trailing data"},
+		{"reserved additional information", []byte{0x1c}, "unsupported additional information"},
+		{"indefinite text", []byte{0x7f, 0x61, 'a', 0xff}, "unsupported additional information"},
+		{"indefinite array", []byte{0x9f, 0x01, 0xff}, "unsupported additional information"},
+		{"indefinite map", []byte{0xbf, 0x61, 'a', 0x01, 0xff}, "unsupported additional information"},
+		{"break", []byte{0xff}, "unsupported additional information"},
+		{"uint overflow", []byte{0x1b, 0x80, 0, 0, 0, 0, 0, 0, 0}, "integer overflow"},
+		{"negative overflow", []byte{0x3b, 0x80, 0, 0, 0, 0, 0, 0, 0}, "integer overflow"},
+		{"oversized text", []byte{0x7b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "unexpected end"},
+		{"oversized bytes", []byte{0x5a, 0x7f, 0xff, 0xff, 0xff}, "unexpected end"},
+		{"oversized array", []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "unexpected end"},
+		{"oversized map", []byte{0xba, 0x7f, 0xff, 0xff, 0xff, 0x01}, "unexpected end"},
+		{"non-string key", []byte{0xa1, 0x01, 0x02}, "map keys must be strings"},
+		{"unassigned simple value", []byte{0xf0}, "unsupported simple value"},
+		{"one-byte simple value", []byte{0xf8, 0x20}, "unsupported simple value"},
+		{"too deep", deep, "nesting too deep"},
+		{"too many tags", deepTags, "nesting too deep"},
+	}
+	for _, tt := range tests {
+		_, err := decodeCBOR(tt.cbor)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.err)
+		}
+	}
+
+	ok := bytes.Repeat([]byte{0x81}, cborMaxDepth)
+	ok = append(ok, 0x00)
+	if _, err := decodeCBOR(ok); err != nil {
+		t.Errorf("nesting at the limit: %s", err)
+	}
+}
+
+func TestCBORCodecRejectsMalformedMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		cbor []byte
+		err  error
+	}{
+		{"truncated", []byte{0xa2, 0x64, 't', 'y', 'p', 'e'}, ErrMalformedMessage},
+		{"not a map", []byte{0x01}, ErrMalformedMessage},
+		{"NaN", []byte{0xa1, 0x64, 't', 'y', 'p', 'e', 0xf9, 0x7e, 0x00}, ErrMalformedMessage},
+		{"unknown type", []byte{0xa1, 0x64, 't', 'y', 'p', 'e', 0x63, 'f', 'o', 'o'}, ErrUnknownType},
+	}
+	for _, tt := range tests {
+		_, err := cborCodec{}.Decode(tt.cbor)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	for _, msg := range []any{
+		map[string]any{"type": "ready", "body": map[string]any{"versions": []any{json.Number("1"), json.Number("2")}}},
+		map[string]any{"type": "vote", "body": map[string]any{"motion": "pause", "agree": true}},
+		map[string]any{"type": "ack", "body": map[string]any{"seq": json.Number("18446744073709551615")}},
+		[]any{json.Number("-1.5"), nil, false, "x"},
+	} {
+		buf := &bytes.Buffer{}
+		encodeCBOR(buf, msg)
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte{0xf9, 0x7e, 0x00})
+	f.Add([]byte{0x9f, 0x01, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, err := decodeCBOR(data)
+		if err != nil {
+			return
+		}
+		// Whatever decodes must encode again, once in its JSON form, and
+		// decode to the same value.
+		msg, err := json.Marshal(v)
+		if err != nil {
+			return
+		}
+		dec := json.NewDecoder(bytes.NewReader(msg))
+		dec.UseNumber()
+		var tree any
+		err = dec.Decode(&tree)
+		if err != nil {
+			t.Fatalf("decoding %s: %s", msg, err)
+		}
+		buf := &bytes.Buffer{}
+		err = encodeCBOR(buf, tree)
+		if err != nil {
+			t.Fatalf("encoding %s: %s", msg, err)
+		}
+		again, err := decodeCBOR(buf.Bytes())
+		if err != nil {
+			t.Fatalf("decoding % x again: %s", buf.Bytes(), err)
+		}
+		againMsg, _ := json.Marshal(again)
+		if !reflect.DeepEqual(normalizeJSON(t, msg), normalizeJSON(t, againMsg)) {
+			t.Fatalf("%s became %s", msg, againMsg)
+		}
+
+		cborCodec{}.Decode(data)
+	})
+}
+
+// normalizeJSON decodes msg with every number as a float64, since CBOR
+// keeps 2 and 2.0 apart and JSON does not.
+func normalizeJSON(t *testing.T, msg []byte) any {
+	t.Helper()
+	var v any
+	err := json.Unmarshal(msg, &v)
+	if err != nil {
+		t.Fatalf("normalizing %s: %s", msg, err)
+	}
+	return v
+}
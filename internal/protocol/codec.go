@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	SubprotocolJSON = "cantstop.json"
+	SubprotocolCBOR = "cantstop.cbor"
+)
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+type Codec interface {
+	Name() string
+	Binary() bool
+	Encode(d Data) ([]byte, error)
+	Decode(msg []byte) (Data, error)
+}
+
+var codecs = []Codec{jsonCodec{}, cborCodec{}}
+
+func Subprotocols() []string {
+	result := make([]string, len(codecs))
+	for i, c := range codecs {
+		result[i] = c.Name()
+	}
+	return result
+}
+
+func CodecFor(subprotocol string) (Codec, error) {
+	if subprotocol == "" {
+		return jsonCodec{}, nil
+	}
+	for _, c := range codecs {
+		if c.Name() == subprotocol {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, subprotocol)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return SubprotocolJSON
+}
+
+func (jsonCodec) Binary() bool {
+	return false
+}
+
+func (jsonCodec) Encode(d Data) ([]byte, error) {
+	return json.Marshal(d)
+}
+
+func (jsonCodec) Decode(msg []byte) (Data, error) {
+	return Decode(msg)
+}
+
+// cborCodec maps messages through their JSON form, so the struct tags of the
+// message catalogue define the field names for both encodings.
+type cborCodec struct{}
+
+func (cborCodec) Name() string {
+	return SubprotocolCBOR
+}
+
+func (cborCodec) Binary() bool {
+	return true
+}
+
+func (cborCodec) Encode(d Data) ([]byte, error) {
+	msg, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	var tree any
+	err = dec.Decode(&tree)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	err = encodeCBOR(buf, tree)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (cborCodec) Decode(msg []byte) (Data, error) {
+	tree, err := decodeCBOR(msg)
+	if err != nil {
+		return Data{}, fmt.Errorf("%w: %s", ErrMalformedMessage, err)
+	}
+	jsonMsg, err := json.Marshal(tree)
+	if err != nil {
+		return Data{}, fmt.Errorf("%w: %s", ErrMalformedMessage, err)
+	}
+	return Decode(jsonMsg)
+}
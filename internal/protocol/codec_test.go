@@ -0,0 +1,124 @@
+package protocol_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	_ "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/cant_stop"
+	_ "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/pig"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+// sample returns a value of type t with every exported field set, so that
+// a round trip shows any field a codec loses.
+func sample(t reflect.Type, depth int) reflect.Value {
+	v := reflect.New(t).Elem()
+	if depth > 8 {
+		return v
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(-100)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(200)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.String:
+		v.SetString("naïve 🎲")
+	case reflect.Slice:
+		v.Set(reflect.Append(v, sample(t.Elem(), depth+1)))
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			v.Index(i).Set(sample(t.Elem(), depth+1))
+		}
+	case reflect.Map:
+		m := reflect.MakeMap(t)
+		m.SetMapIndex(sample(t.Key(), depth+1), sample(t.Elem(), depth+1))
+		v.Set(m)
+	case reflect.Pointer:
+		p := reflect.New(t.Elem())
+		p.Elem().Set(sample(t.Elem(), depth+1))
+		v.Set(p)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				v.Field(i).Set(sample(t.Field(i).Type, depth+1))
+			}
+		}
+	}
+	return v
+}
+
+func codecs(t *testing.T) (jsonCodec, cborCodec protocol.Codec) {
+	t.Helper()
+	jsonCodec, err := protocol.CodecFor(protocol.SubprotocolJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cborCodec, err = protocol.CodecFor(protocol.SubprotocolCBOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jsonCodec, cborCodec
+}
+
+func unmarshal(t *testing.T, msg []byte) any {
+	t.Helper()
+	var v any
+	err := json.Unmarshal(msg, &v)
+	if err != nil {
+		t.Fatalf("unmarshal %s: %s", msg, err)
+	}
+	return v
+}
+
+// TestCodecsAgree sends every registered message through both codecs and
+// checks that the CBOR form carries exactly what the JSON form does.
+func TestCodecsAgree(t *testing.T) {
+	jsonCodec, cborCodec := codecs(t)
+	for _, dir := range []protocol.Direction{protocol.Inbound, protocol.Outbound} {
+		for _, msgType := range protocol.Types(dir) {
+			body, _ := protocol.Lookup(dir, msgType)
+			d := protocol.Data{Seq: 300, Type: msgType}
+			if body != nil {
+				d.Body = sample(body, 0).Interface()
+			}
+
+			jsonMsg, err := jsonCodec.Encode(d)
+			if err != nil {
+				t.Errorf("%s: JSON encode: %s", msgType, err)
+				continue
+			}
+			cborMsg, err := cborCodec.Encode(d)
+			if err != nil {
+				t.Errorf("%s: CBOR encode: %s", msgType, err)
+				continue
+			}
+			tree, err := protocol.DecodeCBOR(cborMsg)
+			if err != nil {
+				t.Errorf("%s: CBOR decode: %s", msgType, err)
+				continue
+			}
+			treeMsg, _ := json.Marshal(tree)
+			if got, want := unmarshal(t, treeMsg), unmarshal(t, jsonMsg); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: CBOR carries %s, JSON %s", msgType, treeMsg, jsonMsg)
+			}
+			if dir == protocol.Outbound {
+				continue
+			}
+
+			fromJSON, jsonErr := jsonCodec.Decode(jsonMsg)
+			fromCBOR, cborErr := cborCodec.Decode(cborMsg)
+			if (jsonErr == nil) != (cborErr == nil) {
+				t.Errorf("%s: JSON decode error %v, CBOR %v", msgType, jsonErr, cborErr)
+				continue
+			}
+			if jsonErr == nil && (!reflect.DeepEqual(fromJSON, fromCBOR) || !reflect.DeepEqual(fromCBOR.Body, d.Body)) {
+				t.Errorf("%s: decoded %+v from JSON and %+v from CBOR, sent %+v", msgType, fromJSON, fromCBOR, d.Body)
+			}
+		}
+	}
+}
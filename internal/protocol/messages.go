@@ -0,0 +1,149 @@
+package protocol
+
+import "errors"
+
+type ReadyBody struct {
+	Versions []int `json:"versions,omitempty"`
+}
+
+type UsernameBody struct {
+	Username string `json:"username"`
+}
+
+type PrepJoinBody struct {
+	RoomId string `json:"roomId"`
+}
+
+func (b PrepJoinBody) Validate() error {
+	if b.RoomId == "" {
+		return errors.New("empty room ID")
+	}
+	return nil
+}
+
+type RulesetBody struct {
+	// Game, if set, also switches the room to another game.
+	Game    string `json:"game,omitempty"`
+	Ruleset int    `json:"ruleset"`
+	// AllowUndo, if set, also changes whether players may take back their
+	// last move.
+	AllowUndo *bool `json:"allowUndo,omitempty"`
+	// FinishRound, if set, also changes whether a won game goes on until the
+	// end of the round.
+	FinishRound *bool `json:"finishRound,omitempty"`
+	// TieBreakers, if set, also replaces the order in which tied players are
+	// ranked. An empty list restores the game's default.
+	TieBreakers []string `json:"tieBreakers,omitempty"`
+}
+
+type ResumeBody struct {
+	Session  string `json:"session"`
+	LastSeq  uint64 `json:"lastSeq"`
+	Versions []int  `json:"versions,omitempty"`
+}
+
+func (b ResumeBody) Validate() error {
+	if b.Session == "" {
+		return errors.New("empty session")
+	}
+	return nil
+}
+
+type AckBody struct {
+	Seq uint64 `json:"seq"`
+}
+
+type ReplayBody struct {
+	From uint64 `json:"from"`
+}
+
+// VoteBody proposes or agrees to a motion on the game being played: pause,
+// unpause or abort. Voting against a motion rejects it.
+type VoteBody struct {
+	Motion string `json:"motion"`
+	Agree  bool   `json:"agree"`
+}
+
+func (b VoteBody) Validate() error {
+	switch b.Motion {
+	case "pause", "unpause", "abort":
+		return nil
+	}
+	return errors.New("unknown motion")
+}
+
+type SessionBody struct {
+	Session string `json:"session"`
+}
+
+type ReplayUnavailableBody struct {
+	From   uint64 `json:"from"`
+	Oldest uint64 `json:"oldest"`
+}
+
+type MaintenanceBody struct {
+	Message    string `json:"message"`
+	Restorable bool   `json:"restorable"`
+}
+
+type AnnouncementBody struct {
+	Message string `json:"message"`
+}
+
+type VersionBody struct {
+	Version int `json:"version"`
+}
+
+type ErrorBody struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+	// RetryAfterMs is set on rateLimited errors.
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
+}
+
+type PrepUpdateBody struct {
+	RoomId      string   `json:"roomId"`
+	IsHosting   bool     `json:"isHosting"`
+	IsReady     bool     `json:"isReady"`
+	Usernames   []string `json:"usernames"`
+	Game        string   `json:"game"`
+	Ruleset     int      `json:"ruleset"`
+	AllowUndo   bool     `json:"allowUndo"`
+	FinishRound bool     `json:"finishRound"`
+	TieBreakers []string `json:"tieBreakers"`
+}
+
+type VoteUpdateBody struct {
+	Motion string   `json:"motion"`
+	Votes  []string `json:"votes"`
+	Voters []string `json:"voters"`
+	// Result is passed or rejected once the vote is over.
+	Result string `json:"result,omitempty"`
+}
+
+func init() {
+	Register(Inbound, "ready", ReadyBody{})
+	Register(Inbound, "username", UsernameBody{})
+	Register(Inbound, "prepNew", nil)
+	Register(Inbound, "prepJoin", PrepJoinBody{})
+	Register(Inbound, "prepLeave", nil)
+	Register(Inbound, "ruleset", RulesetBody{})
+	Register(Inbound, "prepReady", nil)
+	Register(Inbound, "prepUnready", nil)
+	Register(Inbound, "start", nil)
+	Register(Inbound, "resume", ResumeBody{})
+	Register(Inbound, "ack", AckBody{})
+	Register(Inbound, "replay", ReplayBody{})
+	Register(Inbound, "vote", VoteBody{})
+
+	Register(Outbound, "version", VersionBody{})
+	Register(Outbound, "username", nil)
+	Register(Outbound, "prep", nil)
+	Register(Outbound, "error", ErrorBody{})
+	Register(Outbound, "prepUpdate", PrepUpdateBody{})
+	Register(Outbound, "session", SessionBody{})
+	Register(Outbound, "replayUnavailable", ReplayUnavailableBody{})
+	Register(Outbound, "maintenance", MaintenanceBody{})
+	Register(Outbound, "announcement", AnnouncementBody{})
+	Register(Outbound, "voteUpdate", VoteUpdateBody{})
+}
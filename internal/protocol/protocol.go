@@ -0,0 +1,223 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	MinVersion = 1
+	Version    = 2
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrMalformedMessage   = errors.New("malformed message")
+	ErrUnknownType        = errors.New("unknown message type")
+	ErrInvalidBody        = errors.New("invalid message body")
+)
+
+type Data struct {
+	Username string `json:"-"`
+	Seq      uint64 `json:"seq,omitempty"`
+	Type     string `json:"type"`
+	Body     any    `json:"body"`
+}
+
+type Direction int8
+
+const (
+	Inbound  Direction = 0
+	Outbound Direction = 1
+)
+
+type validator interface {
+	Validate() error
+}
+
+type registry struct {
+	mu       *sync.RWMutex
+	messages [2]map[string]reflect.Type
+}
+
+var catalogue = registry{
+	mu:       &sync.RWMutex{},
+	messages: [2]map[string]reflect.Type{{}, {}},
+}
+
+// Register adds a message type to the catalogue. Several games may register
+// the same type as long as they agree on its body.
+func Register(dir Direction, msgType string, body any) {
+	catalogue.mu.Lock()
+	defer catalogue.mu.Unlock()
+
+	var t reflect.Type
+	if body != nil {
+		t = reflect.TypeOf(body)
+	}
+	if old, ok := catalogue.messages[dir][msgType]; ok {
+		if old != t {
+			panic(fmt.Sprintf("protocol: message type %q registered twice with different bodies", msgType))
+		}
+		return
+	}
+	catalogue.messages[dir][msgType] = t
+}
+
+func lookup(dir Direction, msgType string) (reflect.Type, bool) {
+	catalogue.mu.RLock()
+	defer catalogue.mu.RUnlock()
+
+	t, ok := catalogue.messages[dir][msgType]
+	return t, ok
+}
+
+func types(dir Direction) []string {
+	catalogue.mu.RLock()
+	defer catalogue.mu.RUnlock()
+
+	result := make([]string, 0, len(catalogue.messages[dir]))
+	for msgType := range catalogue.messages[dir] {
+		result = append(result, msgType)
+	}
+	slices.Sort(result)
+	return result
+}
+
+func Negotiate(versions []int) (int, error) {
+	if len(versions) == 0 {
+		return MinVersion, nil
+	}
+	best := 0
+	for _, v := range versions {
+		if v >= MinVersion && v <= Version && v > best {
+			best = v
+		}
+	}
+	if best == 0 {
+		return 0, fmt.Errorf("%w: client supports %v, server supports %d to %d", ErrUnsupportedVersion, versions, MinVersion, Version)
+	}
+	return best, nil
+}
+
+func Decode(msg []byte) (Data, error) {
+	env := struct {
+		Type string          `json:"type"`
+		Body json.RawMessage `json:"body"`
+	}{}
+	err := json.Unmarshal(msg, &env)
+	if err != nil {
+		return Data{}, fmt.Errorf("%w: %s", ErrMalformedMessage, err)
+	}
+	if env.Type == "" {
+		return Data{}, fmt.Errorf("%w: missing type", ErrMalformedMessage)
+	}
+
+	t, ok := lookup(Inbound, env.Type)
+	if !ok {
+		return Data{}, fmt.Errorf("%w: %s", ErrUnknownType, env.Type)
+	}
+	if t == nil {
+		return Data{Type: env.Type}, nil
+	}
+
+	body, err := decodeBody(t, env.Body)
+	if err != nil {
+		return Data{}, fmt.Errorf("%w for %s: %s", ErrInvalidBody, env.Type, err)
+	}
+	return Data{Type: env.Type, Body: body}, nil
+}
+
+func decodeBody(t reflect.Type, raw json.RawMessage) (any, error) {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		raw = []byte("{}")
+	}
+
+	v := reflect.New(t)
+	err := json.Unmarshal(raw, v.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Kind() == reflect.Struct {
+		present := map[string]json.RawMessage{}
+		err = json.Unmarshal(raw, &present)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fields(t) {
+			if _, ok := present[f.name]; f.required && !ok {
+				return nil, fmt.Errorf("missing field %s", f.name)
+			}
+		}
+	}
+
+	if val, ok := v.Interface().(validator); ok {
+		err = val.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v.Elem().Interface(), nil
+}
+
+type field struct {
+	name     string
+	index    int
+	required bool
+}
+
+func fields(t reflect.Type) []field {
+	result := []field{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		required := true
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if tag != "" {
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			if slices.Contains(parts[1:], "omitempty") {
+				required = false
+			}
+		}
+		if f.Type.Kind() == reflect.Pointer {
+			required = false
+		}
+		result = append(result, field{
+			name:     name,
+			index:    i,
+			required: required,
+		})
+	}
+	return result
+}
+
+type Downgrader interface {
+	Downgrade(version int) (Data, bool)
+}
+
+func ForVersion(d Data, version int) Data {
+	if dg, ok := d.Body.(Downgrader); ok {
+		if legacy, ok := dg.Downgrade(version); ok {
+			legacy.Username = d.Username
+			legacy.Seq = d.Seq
+			return legacy
+		}
+	}
+	return d
+}
@@ -0,0 +1,187 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testBody struct {
+	Name     string `json:"name"`
+	Count    int8   `json:"count"`
+	Note     string `json:"note,omitempty"`
+	Optional *bool  `json:"optional"`
+	Ignored  string `json:"-"`
+	internal string
+}
+
+func (b testBody) Validate() error {
+	if b.Count < 0 {
+		return errors.New("negative count")
+	}
+	return nil
+}
+
+func init() {
+	Register(Inbound, "test", testBody{})
+	Register(Inbound, "testEmpty", nil)
+}
+
+func TestDecode(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name string
+		msg  string
+		want Data
+		err  error
+		text string
+	}{
+		{
+			name: "all fields",
+			msg:  `{"type": "test", "body": {"name": "a", "count": 2, "note": "b", "optional": true}}`,
+			want: Data{Type: "test", Body: testBody{Name: "a", Count: 2, Note: "b", Optional: &yes}},
+		},
+		{
+			name: "optional fields left out",
+			msg:  `{"type": "test", "body": {"name": "a", "count": 0}}`,
+			want: Data{Type: "test", Body: testBody{Name: "a"}},
+		},
+		{
+			name: "unknown fields ignored",
+			msg:  `{"type": "test", "body": {"name": "a", "count": 1, "extra": [1], "Ignored": "x"}}`,
+			want: Data{Type: "test", Body: testBody{Name: "a", Count: 1}},
+		},
+		{
+			name: "no body",
+			msg:  `{"type": "testEmpty"}`,
+			want: Data{Type: "testEmpty"},
+		},
+		{
+			name: "body ignored",
+			msg:  `{"type": "testEmpty", "body": {"name": "a"}}`,
+			want: Data{Type: "testEmpty"},
+		},
+		{name: "not JSON", msg: `{"type": `, err: ErrMalformedMessage},
+		{name: "not an object", msg: `[1, 2]`, err: ErrMalformedMessage},
+		{name: "type not a string", msg: `{"type": 1}`, err: ErrMalformedMessage},
+		{name: "missing type", msg: `{"body": {}}`, err: ErrMalformedMessage, text: "missing type"},
+		{name: "unknown type", msg: `{"type": "chess"}`, err: ErrUnknownType, text: "chess"},
+		{name: "outbound type", msg: `{"type": "prepUpdate", "body": {}}`, err: ErrUnknownType},
+		{name: "missing body", msg: `{"type": "test"}`, err: ErrInvalidBody, text: "missing field name"},
+		{name: "null body", msg: `{"type": "test", "body": null}`, err: ErrInvalidBody, text: "missing field name"},
+		{name: "missing field", msg: `{"type": "test", "body": {"name": "a"}}`, err: ErrInvalidBody, text: "missing field count"},
+		{name: "body not an object", msg: `{"type": "test", "body": "a"}`, err: ErrInvalidBody},
+		{name: "wrong field type", msg: `{"type": "test", "body": {"name": 1, "count": 1}}`, err: ErrInvalidBody},
+		{name: "field out of range", msg: `{"type": "test", "body": {"name": "a", "count": 300}}`, err: ErrInvalidBody},
+		{name: "validation fails", msg: `{"type": "test", "body": {"name": "a", "count": -1}}`, err: ErrInvalidBody, text: "negative count"},
+	}
+	for _, tt := range tests {
+		got, err := Decode([]byte(tt.msg))
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) || !strings.Contains(err.Error(), tt.text) {
+				t.Errorf("%s: got error %v, want %v containing %q", tt.name, err, tt.err, tt.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeValidatesMessages(t *testing.T) {
+	tests := []struct {
+		msg string
+		ok  bool
+	}{
+		{`{"type": "prepJoin", "body": {"roomId": "ABC"}}`, true},
+		{`{"type": "prepJoin", "body": {"roomId": ""}}`, false},
+		{`{"type": "resume", "body": {"session": "s", "lastSeq": 0}}`, true},
+		{`{"type": "resume", "body": {"session": "", "lastSeq": 0}}`, false},
+		{`{"type": "vote", "body": {"motion": "abort", "agree": false}}`, true},
+		{`{"type": "vote", "body": {"motion": "surrender", "agree": true}}`, false},
+	}
+	for _, tt := range tests {
+		_, err := Decode([]byte(tt.msg))
+		if tt.ok && err != nil {
+			t.Errorf("%s: %s", tt.msg, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidBody) {
+			t.Errorf("%s: got error %v, want %v", tt.msg, err, ErrInvalidBody)
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	Register(Inbound, "test", testBody{})
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering test with another body did not panic")
+		}
+	}()
+	Register(Inbound, "test", VoteBody{})
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		versions []int
+		want     int
+	}{
+		{nil, MinVersion},
+		{[]int{}, MinVersion},
+		{[]int{MinVersion}, MinVersion},
+		{[]int{Version}, Version},
+		{[]int{MinVersion, Version}, Version},
+		{[]int{Version, MinVersion}, Version},
+		{[]int{MinVersion - 1, MinVersion}, MinVersion},
+		{[]int{Version, Version + 1}, Version},
+		{[]int{MinVersion - 1}, 0},
+		{[]int{Version + 1}, 0},
+		{[]int{-1, Version + 5}, 0},
+	}
+	for _, tt := range tests {
+		got, err := Negotiate(tt.versions)
+		if tt.want == 0 {
+			if !errors.Is(err, ErrUnsupportedVersion) {
+				t.Errorf("%v: got version %d, error %v, want %v", tt.versions, got, err, ErrUnsupportedVersion)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%v: got version %d, error %v, want %d", tt.versions, got, err, tt.want)
+		}
+	}
+}
+
+type legacyBody struct {
+	New string `json:"new"`
+}
+
+func (b legacyBody) Downgrade(version int) (Data, bool) {
+	if version >= Version {
+		return Data{}, false
+	}
+	return Data{Type: "legacy", Body: b.New}, true
+}
+
+func TestForVersion(t *testing.T) {
+	d := Data{Username: "alice", Seq: 7, Type: "new", Body: legacyBody{New: "x"}}
+
+	if got := ForVersion(d, Version); !reflect.DeepEqual(got, d) {
+		t.Errorf("version %d: got %+v, want it unchanged", Version, got)
+	}
+	want := Data{Username: "alice", Seq: 7, Type: "legacy", Body: "x"}
+	if got := ForVersion(d, MinVersion); !reflect.DeepEqual(got, want) {
+		t.Errorf("version %d: got %+v, want %+v", MinVersion, got, want)
+	}
+	plain := Data{Type: "log", Body: "x"}
+	if got := ForVersion(plain, MinVersion); !reflect.DeepEqual(got, plain) {
+		t.Errorf("a body without Downgrade: got %+v, want it unchanged", got)
+	}
+}
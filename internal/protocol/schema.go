@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"math"
+	"reflect"
+)
+
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+type schema = map[string]any
+
+func Schema() schema {
+	return schema{
+		"$schema":     SchemaDialect,
+		"title":       "Can't Stop websocket protocol",
+		"version":     Version,
+		"minVersion":  MinVersion,
+		"description": "Every message is a JSON object with a type and a body. Inbound messages are sent by the client, outbound messages by the server.",
+		"$defs": schema{
+			"inbound":  directionSchema(Inbound),
+			"outbound": directionSchema(Outbound),
+		},
+	}
+}
+
+func directionSchema(dir Direction) schema {
+	oneOf := []schema{}
+	for _, msgType := range types(dir) {
+		t, _ := lookup(dir, msgType)
+		body := schema{"type": "null"}
+		if t != nil {
+			body = typeSchema(t)
+		}
+		oneOf = append(oneOf, schema{
+			"type": "object",
+			"properties": schema{
+				"type": schema{"const": msgType},
+				"body": body,
+			},
+			"required": []string{"type"},
+		})
+	}
+	return schema{"oneOf": oneOf}
+}
+
+func typeSchema(t reflect.Type) schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int8:
+		return schema{"type": "integer", "minimum": math.MinInt8, "maximum": math.MaxInt8}
+	case reflect.Int16:
+		return schema{"type": "integer", "minimum": math.MinInt16, "maximum": math.MaxInt16}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return schema{"type": "integer"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint, reflect.Uint64:
+		return schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := schema{}
+		required := []string{}
+		for _, f := range fields(t) {
+			properties[f.name] = typeSchema(t.Field(f.index).Type)
+			if f.required {
+				required = append(required, f.name)
+			}
+		}
+		result := schema{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			result["required"] = required
+		}
+		return result
+	default:
+		return schema{}
+	}
+}
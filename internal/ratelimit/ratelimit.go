@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidQuota = errors.New("invalid quota")
+
+// Limit allows Rate events per second on average and bursts of up to Burst
+// events at once.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Bucket is a token bucket that starts full.
+type Bucket struct {
+	mu     *sync.Mutex
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+func NewBucket(limit Limit) *Bucket {
+	return &Bucket{
+		mu:     &sync.Mutex{},
+		limit:  limit,
+		tokens: float64(limit.Burst),
+	}
+}
+
+// Allow takes a token if there is one. Otherwise it reports how long it
+// will be until the next token is available.
+func (b *Bucket) Allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if b.limit.Rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	}
+	b.last = now
+}
+
+func (b *Bucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+// Keyed keeps a bucket per key, such as per IP address.
+type Keyed struct {
+	mu      *sync.Mutex
+	limit   Limit
+	buckets map[string]*Bucket
+}
+
+func NewKeyed(limit Limit) *Keyed {
+	return &Keyed{
+		mu:      &sync.Mutex{},
+		limit:   limit,
+		buckets: map[string]*Bucket{},
+	}
+}
+
+func (k *Keyed) Allow(key string, now time.Time) (bool, time.Duration) {
+	k.mu.Lock()
+	b, ok := k.buckets[key]
+	if !ok {
+		b = NewBucket(k.limit)
+		k.buckets[key] = b
+	}
+	k.mu.Unlock()
+	return b.Allow(now)
+}
+
+// Prune forgets the buckets that have refilled completely, since a new
+// bucket would behave the same.
+func (k *Keyed) Prune(now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for key, b := range k.buckets {
+		if b.full(now) {
+			delete(k.buckets, key)
+		}
+	}
+}
+
+// ParseQuota parses a quota such as "prepNew:5/1m", which allows 5 messages
+// of type prepNew per minute.
+func ParseQuota(s string) (string, Limit, error) {
+	typ, rest, ok := strings.Cut(s, ":")
+	if !ok || typ == "" {
+		return "", Limit{}, fmt.Errorf("%w %q: want type:count/window", ErrInvalidQuota, s)
+	}
+	countStr, windowStr, ok := strings.Cut(rest, "/")
+	if !ok {
+		return "", Limit{}, fmt.Errorf("%w %q: want type:count/window", ErrInvalidQuota, s)
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count <= 0 {
+		return "", Limit{}, fmt.Errorf("%w %q: count must be a positive integer", ErrInvalidQuota, s)
+	}
+	window, err := time.ParseDuration(windowStr)
+	if err != nil || window <= 0 {
+		return "", Limit{}, fmt.Errorf("%w %q: window must be a positive duration", ErrInvalidQuota, s)
+	}
+	return typ, Limit{Rate: float64(count) / window.Seconds(), Burst: count}, nil
+}
@@ -0,0 +1,163 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// A step happens at offset from start and expects Allow to answer ok and
+// wait.
+type step struct {
+	offset time.Duration
+	ok     bool
+	wait   time.Duration
+}
+
+func TestBucket(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Limit
+		steps []step
+	}{
+		{
+			name:  "burst then wait",
+			limit: Limit{Rate: 1, Burst: 2},
+			steps: []step{
+				{0, true, 0},
+				{0, true, 0},
+				{0, false, time.Second},
+				{500 * time.Millisecond, false, 500 * time.Millisecond},
+				{time.Second, true, 0},
+				{time.Second, false, time.Second},
+			},
+		},
+		{
+			name:  "refills no further than the burst",
+			limit: Limit{Rate: 1, Burst: 2},
+			steps: []step{
+				{0, true, 0},
+				{0, true, 0},
+				{time.Hour, true, 0},
+				{time.Hour, true, 0},
+				{time.Hour, false, time.Second},
+			},
+		},
+		{
+			name:  "fractional rate",
+			limit: Limit{Rate: 0.1, Burst: 1},
+			steps: []step{
+				{0, true, 0},
+				{5 * time.Second, false, 5 * time.Second},
+				{10 * time.Second, true, 0},
+			},
+		},
+		{
+			name:  "clock going backwards",
+			limit: Limit{Rate: 1, Burst: 1},
+			steps: []step{
+				{10 * time.Second, true, 0},
+				{5 * time.Second, false, time.Second},
+				{11 * time.Second, true, 0},
+			},
+		},
+		{
+			name:  "no refill",
+			limit: Limit{Rate: 0, Burst: 1},
+			steps: []step{
+				{0, true, 0},
+				{time.Hour, false, time.Duration(math.MaxInt64)},
+			},
+		},
+		{
+			name:  "empty",
+			limit: Limit{Rate: 1, Burst: 0},
+			steps: []step{
+				{0, false, time.Second},
+				{time.Hour, false, time.Second},
+			},
+		},
+	}
+	for _, tt := range tests {
+		b := NewBucket(tt.limit)
+		for n, s := range tt.steps {
+			ok, wait := b.Allow(start.Add(s.offset))
+			if ok != s.ok || wait != s.wait {
+				t.Errorf("%s: step %d: got %t, wait %s, want %t, wait %s", tt.name, n, ok, wait, s.ok, s.wait)
+			}
+		}
+	}
+}
+
+func TestKeyedIsolatesKeys(t *testing.T) {
+	k := NewKeyed(Limit{Rate: 1, Burst: 1})
+
+	if ok, _ := k.Allow("a", start); !ok {
+		t.Fatalf("first message from a was refused")
+	}
+	if ok, _ := k.Allow("a", start); ok {
+		t.Errorf("second message from a was allowed")
+	}
+	if ok, _ := k.Allow("b", start); !ok {
+		t.Errorf("first message from b was refused after a used up its bucket")
+	}
+}
+
+func TestKeyedPrune(t *testing.T) {
+	k := NewKeyed(Limit{Rate: 1, Burst: 2})
+	k.Allow("old", start)
+	k.Allow("new", start.Add(time.Second))
+	k.Allow("new", start.Add(time.Second))
+
+	k.Prune(start.Add(2 * time.Second))
+	if _, ok := k.buckets["old"]; ok {
+		t.Errorf("the bucket of old was kept once it refilled")
+	}
+	if _, ok := k.buckets["new"]; !ok {
+		t.Fatalf("the bucket of new was pruned before it refilled")
+	}
+	if ok, _ := k.Allow("new", start.Add(2*time.Second)); !ok {
+		t.Errorf("new was refused after a second")
+	}
+	if ok, _ := k.Allow("new", start.Add(2*time.Second)); ok {
+		t.Errorf("new was allowed a full bucket after pruning")
+	}
+}
+
+func TestParseQuota(t *testing.T) {
+	tests := []struct {
+		quota string
+		typ   string
+		limit Limit
+		err   bool
+	}{
+		{"prepNew:5/1m", "prepNew", Limit{Rate: 5.0 / 60, Burst: 5}, false},
+		{"start:1/500ms", "start", Limit{Rate: 2, Burst: 1}, false},
+		{"a:b:3/1s", "a", Limit{}, true},
+		{"prepNew", "", Limit{}, true},
+		{":5/1m", "", Limit{}, true},
+		{"prepNew:5", "", Limit{}, true},
+		{"prepNew:five/1m", "", Limit{}, true},
+		{"prepNew:0/1m", "", Limit{}, true},
+		{"prepNew:-1/1m", "", Limit{}, true},
+		{"prepNew:5/", "", Limit{}, true},
+		{"prepNew:5/1", "", Limit{}, true},
+		{"prepNew:5/0s", "", Limit{}, true},
+		{"prepNew:5/-1m", "", Limit{}, true},
+	}
+	for _, tt := range tests {
+		typ, limit, err := ParseQuota(tt.quota)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidQuota) {
+				t.Errorf("%q: got error %v, want %v", tt.quota, err, ErrInvalidQuota)
+			}
+			continue
+		}
+		if err != nil || typ != tt.typ || limit != tt.limit {
+			t.Errorf("%q: got %q %+v, error %v, want %q %+v", tt.quota, typ, limit, err, tt.typ, tt.limit)
+		}
+	}
+}
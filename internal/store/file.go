@@ -0,0 +1,212 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+)
+
+const maxEntrySize = 1 << 20
+
+var ErrClosed = errors.New("the game store is closed")
+
+// FileStore is a GameStore backed by an append-only file with one JSON entry
+// per line. Every entry is synced before the call returns, so a crash loses
+// at most the entry being written, which is dropped when the file is opened
+// again.
+type FileStore struct {
+	mu         *sync.Mutex
+	path       string
+	file       *os.File
+	lastErr    error
+	unfinished []Game
+}
+
+type entry struct {
+	Op    string      `json:"op"`
+	Id    string      `json:"id"`
+	Game  *Game       `json:"game,omitempty"`
+	Event *game.Event `json:"event,omitempty"`
+}
+
+// OpenFile reads the games recorded in path, then rewrites the file so that
+// it only holds the unfinished ones and keeps appending to it.
+func OpenFile(path string) (*FileStore, error) {
+	unfinished, err := readEntries(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		mu:         &sync.Mutex{},
+		path:       path,
+		unfinished: unfinished,
+	}
+	err = s.compact()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func readEntries(path string) ([]Game, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Game{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	games := []*Game{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEntrySize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		e := entry{}
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			slog.Warn("ignoring the rest of the game store", "path", path, "line", line, "err", err)
+			break
+		}
+		i := slices.IndexFunc(games, func(g *Game) bool { return g.Id == e.Id })
+		switch {
+		case e.Op == "create" && e.Game != nil && i == -1:
+			g := *e.Game
+			g.Id = e.Id
+			games = append(games, &g)
+		case e.Op == "event" && e.Event != nil && i != -1:
+			games[i].Events = append(games[i].Events, *e.Event)
+		case e.Op == "finish" && i != -1:
+			games = slices.Delete(games, i, i+1)
+		default:
+			slog.Warn("ignoring game store entry", "path", path, "line", line, "op", e.Op, "game", e.Id)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Warn("ignoring the rest of the game store", "path", path, "err", err)
+	}
+
+	result := make([]Game, 0, len(games))
+	for _, g := range games {
+		result = append(result, *g)
+	}
+	return result, nil
+}
+
+func (s *FileStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, g := range s.unfinished {
+		err = writeEntry(w, entry{Op: "create", Id: g.Id, Game: &g})
+		for _, e := range g.Events {
+			if err != nil {
+				break
+			}
+			err = writeEntry(w, entry{Op: "event", Id: g.Id, Event: &e})
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		return err
+	}
+
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	return err
+}
+
+func writeEntry(w *bufio.Writer, e entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (s *FileStore) append(e entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if len(data) >= maxEntrySize {
+		return fmt.Errorf("entry for game %s is too large", e.Id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return ErrClosed
+	}
+	_, err = s.file.Write(append(data, '\n'))
+	if err == nil {
+		err = s.file.Sync()
+	}
+	s.lastErr = err
+	return err
+}
+
+func (s *FileStore) Create(g Game) error {
+	return s.append(entry{Op: "create", Id: g.Id, Game: &g})
+}
+
+func (s *FileStore) Append(id string, e game.Event) error {
+	return s.append(entry{Op: "event", Id: id, Event: &e})
+}
+
+func (s *FileStore) Finish(id string) error {
+	return s.append(entry{Op: "finish", Id: id})
+}
+
+func (s *FileStore) Unfinished() []Game {
+	return s.unfinished
+}
+
+func (s *FileStore) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return ErrClosed
+	}
+	return s.lastErr
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+)
+
+// A GameStore records running games as they are played so they can be
+// rebuilt after the process dies. A game is created once, gets an event for
+// every player input, and is finished when it is over.
+type GameStore interface {
+	Create(g Game) error
+	Append(id string, e game.Event) error
+	Finish(id string) error
+	// Unfinished returns the games that were running when the store was
+	// last closed or when the process died.
+	Unfinished() []Game
+	// Check reports whether the store can currently record games.
+	Check() error
+	Close() error
+}
+
+// Name is the kind of game, see game.Game. Games recorded before other games
+// were supported have no name and are Can't Stop.
+type Game struct {
+	Id       string            `json:"id"`
+	Name     string            `json:"name,omitempty"`
+	RoomId   string            `json:"roomId"`
+	Setup    game.Setup        `json:"setup"`
+	Sessions map[string]string `json:"sessions"`
+	Events   []game.Event      `json:"-"`
+}
+
+// Nop is a GameStore that keeps nothing.
+type Nop struct{}
+
+func (Nop) Create(g Game) error                  { return nil }
+func (Nop) Append(id string, e game.Event) error { return nil }
+func (Nop) Finish(id string) error               { return nil }
+func (Nop) Unfinished() []Game                   { return nil }
+func (Nop) Check() error                         { return nil }
+func (Nop) Close() error                         { return nil }
@@ -0,0 +1,184 @@
+package username
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrEmpty       = errors.New("the username is empty")
+	ErrTooLong     = errors.New("the username is too long")
+	ErrInvalidChar = errors.New("the username contains characters that are not allowed")
+	ErrMixedScript = errors.New("the username mixes Latin, Greek and Cyrillic letters")
+	ErrReserved    = errors.New("the username is reserved")
+	ErrOffensive   = errors.New("the username is not allowed")
+)
+
+// A Filter reports whether a username is offensive. It is given the
+// skeleton of the name, see Skeleton.
+type Filter interface {
+	Offensive(skeleton string) bool
+}
+
+type FilterFunc func(skeleton string) bool
+
+func (f FilterFunc) Offensive(skeleton string) bool {
+	return f(skeleton)
+}
+
+// WordFilter rejects every name whose skeleton contains one of its words.
+type WordFilter struct {
+	words []string
+}
+
+func NewWordFilter(words []string) *WordFilter {
+	f := &WordFilter{}
+	for _, w := range words {
+		if s := Skeleton(w); s != "" {
+			f.words = append(f.words, s)
+		}
+	}
+	return f
+}
+
+func (f *WordFilter) Offensive(skeleton string) bool {
+	for _, w := range f.words {
+		if strings.Contains(skeleton, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy decides which usernames are accepted.
+type Policy struct {
+	maxLen   int
+	reserved map[string]bool
+	filter   Filter
+}
+
+// NewPolicy returns a policy allowing names of up to maxLen characters
+// other than the reserved ones. filter may be nil.
+func NewPolicy(maxLen int, reserved []string, filter Filter) *Policy {
+	p := &Policy{
+		maxLen:   maxLen,
+		reserved: map[string]bool{},
+		filter:   filter,
+	}
+	for _, name := range reserved {
+		p.reserved[Skeleton(name)] = true
+	}
+	return p
+}
+
+// Check normalizes name and returns it if the policy accepts it.
+func (p *Policy) Check(name string) (string, error) {
+	name, err := Normalize(name)
+	if err != nil {
+		return "", err
+	}
+	if utf8.RuneCountInString(name) > p.maxLen {
+		return "", ErrTooLong
+	}
+	skeleton := Skeleton(name)
+	if p.reserved[skeleton] {
+		return "", ErrReserved
+	}
+	if p.filter != nil && p.filter.Offensive(skeleton) {
+		return "", ErrOffensive
+	}
+	return name, nil
+}
+
+// Normalize applies NFKC, which folds fullwidth forms to ASCII and composes
+// accented letters, trims spaces and collapses runs of spaces into one. The
+// result may only contain letters, digits, spaces, '_', '-' and '.', and
+// letters from at most one of the Latin, Greek and Cyrillic scripts, whose
+// look-alikes could otherwise pass for one another.
+func Normalize(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", ErrInvalidChar
+	}
+	var b strings.Builder
+	space := false
+	var script *unicode.RangeTable
+	for _, r := range norm.NFKC.String(name) {
+		switch {
+		case unicode.IsSpace(r):
+			space = b.Len() > 0
+			continue
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '_', r == '-', r == '.':
+		default:
+			return "", ErrInvalidChar
+		}
+		if s := confusableScript(r); s != nil {
+			if script != nil && script != s {
+				return "", ErrMixedScript
+			}
+			script = s
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "", ErrEmpty
+	}
+	return b.String(), nil
+}
+
+// confusableScript returns the script of r if it is one of those whose
+// letters are easily mistaken for each other's.
+func confusableScript(r rune) *unicode.RangeTable {
+	for _, s := range []*unicode.RangeTable{unicode.Latin, unicode.Greek, unicode.Cyrillic} {
+		if unicode.Is(s, r) {
+			return s
+		}
+	}
+	return nil
+}
+
+// Key is the form under which usernames are compared for uniqueness, so
+// that names differing only in case, or written in Greek or Cyrillic
+// letters that look like Latin ones, are the same user.
+func Key(name string) string {
+	return strings.Map(func(r rune) rune {
+		return foldCase(unconfuse(r))
+	}, name)
+}
+
+func foldCase(r rune) rune {
+	return unicode.ToLower(unicode.ToUpper(r))
+}
+
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'8': 'b',
+}
+
+// Skeleton reduces a name to lower-case letters with separators dropped and
+// look-alike digits and letters replaced, so that "R00T", "r.o.o.t" and
+// "rооt" with Cyrillic o's all become "root".
+func Skeleton(name string) string {
+	return strings.Map(func(r rune) rune {
+		r = unconfuse(r)
+		if l, ok := leet[r]; ok {
+			return l
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return foldCase(r)
+		}
+		return -1
+	}, norm.NFKC.String(name))
+}
@@ -0,0 +1,131 @@
+package username
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"alice", "alice", nil},
+		{"  alice  ", "alice", nil},
+		{"Mary   Jane", "Mary Jane", nil},
+		{"a_b-c.d", "a_b-c.d", nil},
+		{"ａｌｉｃｅ１２", "alice12", nil},
+		{"ａ　ｂ", "a b", nil},
+		{"José", "José", nil},
+		{"ﬁsh", "fish", nil},
+		{"Ὀδυσσεύς", "Ὀδυσσεύς", nil},
+		{"Юлия", "Юлия", nil},
+		{"太郎", "太郎", nil},
+		{"", "", ErrEmpty},
+		{" \t ", "", ErrEmpty},
+		{"alice!", "", ErrInvalidChar},
+		{"al\x00ice", "", ErrInvalidChar},
+		{"al\xffice", "", ErrInvalidChar},
+		{"a​b", "", ErrInvalidChar},
+		{"q́", "", ErrInvalidChar},
+		{"аdmin", "", ErrMixedScript},
+		{"pΑul", "", ErrMixedScript},
+		{"Юлия Smith", "", ErrMixedScript},
+		{"Smith太郎", "Smith太郎", nil},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.name)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v, want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"Alice", "aLICE", true},
+		{"ace", "асе", true},
+		{"HOP", "НΟР", true},
+		{"paul", "раuӏ", true},
+		{"r00t", "root", false},
+		{"a.b", "ab", false},
+		{"Юлия", "юлия", true},
+	}
+	for _, tt := range tests {
+		if same := Key(tt.a) == Key(tt.b); same != tt.same {
+			t.Errorf("Key(%q) == Key(%q) is %t, want %t", tt.a, tt.b, same, tt.same)
+		}
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"root", "root"},
+		{"R00T", "root"},
+		{"r.o.o.t", "root"},
+		{"r_0-0 T", "root"},
+		{"rооt", "root"},
+		{"ＲＯＯＴ", "root"},
+		{"4dm1n", "admin"},
+		{"Αdmin", "admin"},
+		{"...", ""},
+	}
+	for _, tt := range tests {
+		if got := Skeleton(tt.name); got != tt.want {
+			t.Errorf("Skeleton(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p := NewPolicy(8, []string{"admin", "Root", "space"}, NewWordFilter([]string{"darn", "h3ck", " "}))
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"alice", "alice", nil},
+		{"12345678", "12345678", nil},
+		{"ａｂｃｄｅｆｇｈ", "abcdefgh", nil},
+		{"123456789", "", ErrTooLong},
+		{"ADMIN", "", ErrReserved},
+		{"a.dmin", "", ErrReserved},
+		{"4dm1n", "", ErrReserved},
+		{"ѕрасе", "", ErrReserved},
+		{"r00t", "", ErrReserved},
+		{"rooted", "rooted", nil},
+		{"darnit", "", ErrOffensive},
+		{"D-4-R-N", "", ErrOffensive},
+		{"oh heck", "", ErrOffensive},
+		{"рау", "рау", nil},
+		{"!", "", ErrInvalidChar},
+		{"аdmin", "", ErrMixedScript},
+	}
+	for _, tt := range tests {
+		got, err := p.Check(tt.name)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Check(%q) = %q, %v, want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+
+	if _, err := NewPolicy(20, nil, nil).Check("darn"); err != nil {
+		t.Errorf("a policy without a filter refused darn: %s", err)
+	}
+}
+
+func TestFilterFunc(t *testing.T) {
+	p := NewPolicy(20, nil, FilterFunc(func(skeleton string) bool { return skeleton == "bob" }))
+	if _, err := p.Check("B.0.B"); !errors.Is(err, ErrOffensive) {
+		t.Errorf("got error %v for B.0.B, want %v", err, ErrOffensive)
+	}
+	if _, err := p.Check("bobby"); err != nil {
+		t.Errorf("bobby: %s", err)
+	}
+}
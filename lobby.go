@@ -2,97 +2,206 @@ package main
 
 import (
 	"errors"
-	"log"
+	"log/slog"
 	"math/rand"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
-)
-
-const (
-	MaxLenUsername     = 20
-	MaxNumRooms        = 20
-	MaxNumUsersTotal   = 10
-	MaxNumUsersPerRoom = 5
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/config"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/ratelimit"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/store"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/username"
 )
 
 var (
 	ErrTooManyRooms       = errors.New("too many rooms")
 	ErrTooManyUsers       = errors.New("too many users")
 	ErrUsernameUsed       = errors.New("the username is used")
+	ErrUsernameSet        = errors.New("the user already has a username")
 	ErrTooManyUsersInRoom = errors.New("too many users in the room")
 	ErrUserNotExist       = errors.New("the user does not exist")
 	ErrRoomNotExist       = errors.New("the room does not exist")
+	ErrSeatReserved       = errors.New("the seat is reserved for another session")
+	ErrDraining           = errors.New("the server is shutting down")
+	ErrMaintenance        = errors.New("the server is in maintenance mode")
+	ErrBanned             = errors.New("banned")
+	ErrNotHost            = errors.New("only the host can start the game")
+	ErrNotAllReady        = errors.New("not everyone is ready")
+	ErrGameInProgress     = errors.New("a game is already running in the room")
+	ErrNotInGame          = errors.New("not in a game")
+	ErrInvalidMotion      = errors.New("the motion does not apply to the game")
+	ErrVotePending        = errors.New("another vote is in progress")
 )
 
 type Lobby struct {
-	mu    *sync.Mutex
-	rooms []*Room
-	users []*User
+	cfg              config.Config
+	mu               *sync.Mutex
+	rooms            []*Room
+	users            []*User
+	store            store.GameStore
+	bans             *banList
+	ipLimiter        *ratelimit.Keyed
+	quotas           map[string]ratelimit.Limit
+	usernames        *username.Policy
+	defaultGame      game.Game
+	draining         bool
+	maintenance      bool
+	queueDropped     *atomic.Int64
+	queueDisconnects *atomic.Int64
+	queueMaxDepth    *atomic.Int64
 }
 
-func initializeLobby() *Lobby {
+func initializeLobby(cfg config.Config) *Lobby {
+	defaultGame, err := lookupGame(defaultGameName)
+	if err != nil {
+		panic(err)
+	}
 	return &Lobby{
-		mu:    &sync.Mutex{},
-		rooms: make([]*Room, 0, MaxNumRooms),
-		users: make([]*User, 0, MaxNumUsersTotal),
+		cfg:              cfg,
+		mu:               &sync.Mutex{},
+		rooms:            make([]*Room, 0, cfg.MaxNumRooms),
+		users:            make([]*User, 0, cfg.MaxNumUsersTotal),
+		store:            store.Nop{},
+		bans:             newBanList(),
+		ipLimiter:        ratelimit.NewKeyed(ratelimit.Limit{Rate: float64(cfg.IPRateLimit), Burst: cfg.IPRateBurst}),
+		quotas:           parseQuotas(cfg.MessageQuotas),
+		usernames:        username.NewPolicy(cfg.MaxLenUsername, cfg.ReservedUsernames, username.NewWordFilter(cfg.BlockedWords)),
+		defaultGame:      defaultGame,
+		queueDropped:     &atomic.Int64{},
+		queueDisconnects: &atomic.Int64{},
+		queueMaxDepth:    &atomic.Int64{},
 	}
 }
 
-func (l *Lobby) createUser(conn *websocket.Conn) (*User, error) {
+func (l *Lobby) createUser(conn *websocket.Conn, codec protocol.Codec) (*User, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if len(l.users) >= MaxNumUsersTotal {
+	if l.draining {
+		return nil, ErrDraining
+	}
+	if _, ok := l.bans.check(banIP, remoteIP(conn.RemoteAddr().String())); ok {
+		return nil, ErrBanned
+	}
+	if len(l.users) >= l.cfg.MaxNumUsersTotal {
 		return nil, ErrTooManyUsers
 	}
 
 	u := &User{
-		conn:     conn,
-		lobby:    l,
-		room:     nil,
-		username: "",
-		toUser:   make(chan Data),
+		mu:            &sync.Mutex{},
+		wmu:           &sync.Mutex{},
+		qmu:           &sync.Mutex{},
+		conn:          conn,
+		codec:         codec,
+		remoteAddr:    conn.RemoteAddr().String(),
+		lobby:         l,
+		room:          nil,
+		username:      "",
+		session:       randSession(),
+		connectedAt:   time.Now(),
+		lastActive:    time.Now(),
+		replay:        make([]Data, 0, l.cfg.ReplayBufferSize+1),
+		resyncPending: &atomic.Bool{},
+		dropped:       0,
+		toUser:        make(chan queued, l.cfg.SendQueueSize),
+		limiter:       ratelimit.NewBucket(ratelimit.Limit{Rate: float64(l.cfg.UserRateLimit), Burst: l.cfg.UserRateBurst}),
+		strikes:       ratelimit.NewBucket(ratelimit.Limit{Rate: float64(l.cfg.AbuseStrikes) / 60, Burst: l.cfg.AbuseStrikes}),
+		quotas:        map[string]*ratelimit.Bucket{},
+		done:          make(chan struct{}),
 	}
 	l.users = append(l.users, u)
+	usersCreated.Inc()
 	return u, nil
 }
 
-func (l *Lobby) findUserByUsername(username string) *User {
+func (l *Lobby) findUserByUsername(name string) *User {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	key := username.Key(name)
 	for _, u := range l.users {
-		if u.username == username {
+		if u.username != "" && username.Key(u.username) == key {
 			return u
 		}
 	}
 	return nil
 }
 
+// claimUsername gives u the username unless another user has one that
+// differs from it only in case.
+func (l *Lobby) claimUsername(u *User, name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if u.username != "" {
+		return ErrUsernameSet
+	}
+	key := username.Key(name)
+	for _, o := range l.users {
+		if o.username != "" && username.Key(o.username) == key {
+			return ErrUsernameUsed
+		}
+	}
+	u.mu.Lock()
+	u.username = name
+	u.mu.Unlock()
+	return nil
+}
+
+func (l *Lobby) findUserBySession(session string) *User {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, u := range l.users {
+		if u.session == session {
+			return u
+		}
+	}
+	return nil
+}
+
+func (l *Lobby) setSession(u *User, session string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u.mu.Lock()
+	u.session = session
+	u.mu.Unlock()
+}
+
 func (l *Lobby) deleteUser(u *User) {
 	if u == nil {
-		log.Printf("deleteUser: received nil User")
+		slog.Error("deleteUser: received nil User")
 		return
 	}
 
 	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	i := slices.Index(l.users, u)
 	if i == -1 {
-		log.Printf("deleteUser: user does not exist")
+		u.logger().Warn("deleteUser: user does not exist")
 		return
 	}
 	l.users = slices.Delete(l.users, i, i+1)
-	l.mu.Unlock()
 }
 
 func (l *Lobby) newRoom() (*Room, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if len(l.rooms) >= MaxNumRooms {
+	if l.draining {
+		return nil, ErrDraining
+	}
+	if l.maintenance {
+		return nil, ErrMaintenance
+	}
+	if len(l.rooms) >= l.cfg.MaxNumRooms {
 		return nil, ErrTooManyRooms
 	}
 
@@ -102,14 +211,19 @@ func (l *Lobby) newRoom() (*Room, error) {
 	}
 
 	r := &Room{
-		mu:           &sync.RWMutex{},
-		id:           id,
-		players:      make([]RoomPlayer, 0, MaxNumUsersPerRoom),
-		toGame:       nil,
-		fromGame:     nil,
-		indexRuleset: 0,
+		mu:               &sync.RWMutex{},
+		id:               id,
+		maxPlayers:       l.cfg.MaxNumUsersPerRoom,
+		hostDecidesPause: l.cfg.HostDecidesPause,
+		players:          make([]RoomPlayer, 0, l.cfg.MaxNumUsersPerRoom),
+		toGame:           nil,
+		fromGame:         nil,
+		game:             l.defaultGame,
+		indexRuleset:     game.DefaultRuleset(l.defaultGame).Index,
+		store:            l.store,
 	}
 	l.rooms = append(l.rooms, r)
+	roomsCreated.Inc()
 
 	return r, nil
 }
@@ -138,20 +252,25 @@ func (l *Lobby) findRoomById(roomId string) *Room {
 	return nil
 }
 
-func (l *Lobby) deleteRoom(r *Room) {
-	if r == nil {
-		log.Printf("deleteRoom: received nil Room")
+// deleteRoomIfEmpty deletes r if nobody is left in it. A deleted room is
+// closed so that nobody who found it just before can join it.
+func (l *Lobby) deleteRoomIfEmpty(r *Room) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r.mu.Lock()
+	empty := len(r.players) == 0 && !r.closed
+	if empty {
+		r.closed = true
+	}
+	r.mu.Unlock()
+	if !empty {
 		return
 	}
 
-	l.mu.Lock()
 	i := slices.Index(l.rooms, r)
-	if i == -1 {
-		log.Printf("deleteRoom: room does not exist")
-		return
+	if i != -1 {
+		l.rooms = slices.Delete(l.rooms, i, i+1)
 	}
-	l.rooms = slices.Delete(l.rooms, i, i+1)
-	l.mu.Unlock()
-
-	log.Printf("deleted room %s", r.id)
+	r.logger().Info("deleted room")
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/config"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+func TestLogin(t *testing.T) {
+	s := newTestServer(t, nil)
+	c := s.dial("alice")
+
+	c.send("ready", protocol.ReadyBody{Versions: []int{1, 2}})
+	messages := c.expect("version", "session", "username")
+	if v := decode[protocol.VersionBody](t, messages[0]).Version; v != protocol.Version {
+		t.Errorf("got version %d, want %d", v, protocol.Version)
+	}
+	if decode[protocol.SessionBody](t, messages[1]).Session == "" {
+		t.Error("got an empty session")
+	}
+	for i, m := range messages {
+		if m.Seq != uint64(i+1) {
+			t.Errorf("%s has seq %d, want %d", m.Type, m.Seq, i+1)
+		}
+	}
+
+	c.send("username", protocol.UsernameBody{Username: "alice"})
+	c.expect("prep")
+	c.expectNothing()
+}
+
+func TestUsernameTaken(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.login("alice")
+	c := s.dial("other")
+	c.send("ready", nil)
+	c.expect("version", "session", "username")
+
+	c.send("username", protocol.UsernameBody{Username: "ALICE"})
+	c.expectError("usernameTaken")
+	c.expect("username")
+
+	c.send("username", protocol.UsernameBody{Username: "bob"})
+	c.expect("prep")
+}
+
+func TestTooManyUsers(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.MaxNumUsersTotal = 1
+	})
+	s.login("alice")
+	s.dial("bob").expectClosed()
+}
+
+func TestJoinUnknownRoom(t *testing.T) {
+	s := newTestServer(t, nil)
+	c := s.login("alice")
+
+	c.send("prepJoin", protocol.PrepJoinBody{RoomId: "NOTAROOM"})
+	c.expectError("")
+	c.expect("prep")
+}
+
+func TestRoomFull(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.MaxNumUsersPerRoom = 2
+	})
+	alice := s.login("alice")
+	bob := s.login("bob")
+	carol := s.login("carol")
+	id := s.newRoom("cantstop", 4, alice, bob)
+
+	carol.send("prepJoin", protocol.PrepJoinBody{RoomId: id})
+	carol.expectError("")
+	carol.expect("prep")
+	alice.expectNothing()
+	bob.expectNothing()
+}
+
+func TestTooManyRooms(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.MaxNumRooms = 1
+	})
+	alice := s.login("alice")
+	bob := s.login("bob")
+
+	alice.send("prepNew", nil)
+	alice.expectPrepUpdate(true, true, "alice")
+	bob.send("prepNew", nil)
+	bob.expectError("")
+	bob.expect("prep")
+}
+
+func TestNewRoomRollsBackWhenHostCannotJoin(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.MaxNumRooms = 1
+		cfg.MaxNumUsersPerRoom = 0
+	})
+	alice := s.login("alice")
+
+	alice.send("prepNew", nil)
+	alice.expectError("")
+	alice.expect("prep")
+	if u := s.lobby.findUserByUsername("alice"); u.currentRoom() != nil {
+		t.Errorf("alice is still in room %s", u.currentRoom().id)
+	}
+	s.waitFor("the room to be deleted", func() bool {
+		s.lobby.mu.Lock()
+		defer s.lobby.mu.Unlock()
+		return len(s.lobby.rooms) == 0
+	})
+}
+
+func TestLeavingDeletesEmptyRoom(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.MaxNumRooms = 1
+	})
+	alice := s.login("alice")
+	bob := s.login("bob")
+
+	alice.send("prepNew", nil)
+	id := alice.expectPrepUpdate(true, true, "alice").RoomId
+	alice.send("prepLeave", nil)
+	alice.expect("prep")
+
+	bob.send("prepJoin", protocol.PrepJoinBody{RoomId: id})
+	bob.expectError("")
+	bob.expect("prep")
+	bob.send("prepNew", nil)
+	bob.expectPrepUpdate(true, true, "bob")
+}
+
+// TestConcurrentLogins has clients race for the same usernames: each name
+// must go to exactly one of them.
+func TestConcurrentLogins(t *testing.T) {
+	const numClients, numNames = 24, 6
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.MaxNumUsersTotal = numClients
+	})
+	clients := make([]*testClient, numClients)
+	for i := range clients {
+		clients[i] = s.dial(fmt.Sprintf("client%d", i))
+		clients[i].send("ready", nil)
+	}
+	for i, c := range clients {
+		c.expect("version", "session", "username")
+		c.send("username", protocol.UsernameBody{Username: fmt.Sprintf("player%d", i%numNames)})
+	}
+
+	won := map[string]int{}
+	for i, c := range clients {
+		name := fmt.Sprintf("player%d", i%numNames)
+		m := c.next()
+		switch m.Type {
+		case "prep":
+			won[name]++
+		case "error":
+			if code := decode[protocol.ErrorBody](t, m).Code; code != "usernameTaken" {
+				t.Fatalf("%s: got error code %q, want usernameTaken", c.name, code)
+			}
+		default:
+			t.Fatalf("%s: got %s, want prep or error", c.name, m.Type)
+		}
+	}
+	for i := 0; i < numNames; i++ {
+		name := fmt.Sprintf("player%d", i)
+		if won[name] != 1 {
+			t.Errorf("%s was given to %d clients, want 1", name, won[name])
+		}
+	}
+}
+
+// TestConcurrentRoomChurn has clients create and leave rooms as fast as they
+// can. As there is one room for each client, none may ever be refused, and
+// no room may be left behind.
+func TestConcurrentRoomChurn(t *testing.T) {
+	const numClients, rounds = 8, 25
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.MaxNumRooms = numClients
+		cfg.SendQueueSize = 1024
+	})
+	clients := make([]*testClient, numClients)
+	for i := range clients {
+		clients[i] = s.login(fmt.Sprintf("player%d", i))
+	}
+	for n := 0; n < rounds; n++ {
+		for _, c := range clients {
+			c.send("prepNew", nil)
+			c.send("prepReady", nil)
+			c.send("prepLeave", nil)
+		}
+	}
+	for _, c := range clients {
+		for n := 0; n < rounds; n++ {
+			c.expect("prepUpdate", "prepUpdate", "prep")
+		}
+	}
+
+	s.lobby.mu.Lock()
+	numRooms := len(s.lobby.rooms)
+	s.lobby.mu.Unlock()
+	if numRooms != 0 {
+		t.Errorf("%d rooms left, want 0", numRooms)
+	}
+}
@@ -1,18 +1,75 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-)
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-var addr = flag.String("addr", ":80", "http service address")
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/config"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/logging"
+)
 
 func main() {
-	flag.Parse()
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %s\n", err)
+		os.Exit(2)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up logging: %s\n", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	l := initializeLobby(cfg)
+	err = l.openGameStore()
+	if err != nil {
+		slog.Error("cannot open game store", "path", cfg.GameStorePath, "err", err)
+		os.Exit(1)
+	}
+	defer l.store.Close()
+	l.restoreGames()
+	go l.reapIdleUsers()
+
+	srv, err := initializeServer(l)
+	if err != nil {
+		slog.Error("cannot initialize server", "err", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		slog.Info("starting server", "addr", cfg.Addr)
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server failed", "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
 
-	l := initializeLobby()
-	srv := initializeServer(addr, l)
-	fmt.Println("Starting server on address", *addr)
-	log.Fatal(srv.ListenAndServe())
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Error("cannot shut down HTTP server", "err", err)
+	}
+	l.shutdown(shutdownCtx)
+	slog.Info("shutdown complete")
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/metrics"
+)
+
+var (
+	messagesReceived = metrics.Default.NewCounterVec("cantstop_messages_received_total", "Messages received from clients by type.", "type")
+	messagesSent     = metrics.Default.NewCounterVec("cantstop_messages_sent_total", "Messages sent to clients by type.", "type")
+	usersCreated     = metrics.Default.NewCounter("cantstop_users_created_total", "Connections accepted into the lobby.")
+	roomsCreated     = metrics.Default.NewCounter("cantstop_rooms_created_total", "Rooms created.")
+
+	usernamesRejected = metrics.Default.NewCounterVec("cantstop_usernames_rejected_total", "Usernames rejected, by reason.", "reason")
+
+	rateLimitedMessages = metrics.Default.NewCounterVec("cantstop_rate_limited_messages_total", "Messages rejected by a rate limit, by the limit that applied.", "scope")
+	abuseBans           = metrics.Default.NewCounter("cantstop_abuse_bans_total", "IP addresses banned for exceeding rate limits repeatedly.")
+)
+
+func (l *Lobby) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	metrics.Default.WriteTo(w)
+	l.lobbyMetrics().WriteTo(w)
+}
+
+// lobbyMetrics reports the current state of the lobby. It is built on every
+// scrape, so it holds no state of its own.
+func (l *Lobby) lobbyMetrics() *metrics.Registry {
+	reg := metrics.NewRegistry()
+	users := reg.NewGauge("cantstop_connected_users", "Users currently connected, including sessions waiting to be resumed.")
+	rooms := reg.NewGaugeVec("cantstop_rooms", "Rooms by state.", "state")
+	depth := reg.NewGauge("cantstop_send_queue_depth", "Messages waiting in all send queues.")
+	maxDepth := reg.NewGauge("cantstop_send_queue_max_depth", "Deepest send queue seen since the server started.")
+	capacity := reg.NewGauge("cantstop_send_queue_capacity", "Capacity of each send queue.")
+	dropped := reg.NewCounter("cantstop_send_queue_dropped_total", "Messages dropped because a send queue was full.")
+	disconnects := reg.NewCounter("cantstop_send_queue_disconnects_total", "Clients disconnected because their send queue was full.")
+
+	l.mu.Lock()
+	allRooms := slices.Clone(l.rooms)
+	users.Set(float64(len(l.users)))
+	for _, u := range l.users {
+		depth.Add(float64(len(u.toUser)))
+	}
+	l.mu.Unlock()
+
+	rooms.With("waiting").Set(0)
+	rooms.With("playing").Set(0)
+	for _, r := range allRooms {
+		r.mu.RLock()
+		state := "waiting"
+		if r.toGame != nil {
+			state = "playing"
+		}
+		r.mu.RUnlock()
+		rooms.With(state).Add(1)
+	}
+
+	maxDepth.Set(float64(l.queueMaxDepth.Load()))
+	capacity.Set(float64(l.cfg.SendQueueSize))
+	dropped.Add(float64(l.queueDropped.Load()))
+	disconnects.Add(float64(l.queueDisconnects.Load()))
+	return reg
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/ratelimit"
+)
+
+const abuseReason = "too many messages"
+
+func parseQuotas(quotas []string) map[string]ratelimit.Limit {
+	result := map[string]ratelimit.Limit{}
+	for _, q := range quotas {
+		typ, limit, err := ratelimit.ParseQuota(q)
+		if err == nil {
+			result[typ] = limit
+		}
+	}
+	return result
+}
+
+// allowMessage applies the per-user and per-IP limits to a message that has
+// just been read.
+func (u *User) allowMessage(now time.Time) bool {
+	if ok, wait := u.limiter.Allow(now); !ok {
+		u.rateLimited("user", "", wait)
+		return false
+	}
+	if ok, wait := u.lobby.ipLimiter.Allow(remoteIP(u.remoteAddr), now); !ok {
+		u.rateLimited("ip", "", wait)
+		return false
+	}
+	return true
+}
+
+// allowType applies the quota for messages of type typ, if there is one.
+func (u *User) allowType(typ string, now time.Time) bool {
+	limit, ok := u.lobby.quotas[typ]
+	if !ok {
+		return true
+	}
+	b, ok := u.quotas[typ]
+	if !ok {
+		b = ratelimit.NewBucket(limit)
+		u.quotas[typ] = b
+	}
+	if ok, wait := b.Allow(now); !ok {
+		u.rateLimited("type", typ, wait)
+		return false
+	}
+	return true
+}
+
+func (u *User) rateLimited(scope string, typ string, wait time.Duration) {
+	rateLimitedMessages.With(scope).Inc()
+	u.logger().Info("rate limited", "scope", scope, "type", typ, "wait", wait)
+	u.enqueue(Data{
+		Type: "error",
+		Body: protocol.ErrorBody{
+			Error:        "too many messages, slow down",
+			Code:         "rateLimited",
+			RetryAfterMs: wait.Milliseconds(),
+		},
+	})
+
+	if ok, _ := u.strikes.Allow(time.Now()); ok {
+		return
+	}
+	ip := remoteIP(u.remoteAddr)
+	b, ok := u.lobby.bans.check(banIP, ip)
+	if !ok {
+		b = u.lobby.bans.add(banIP, ip, abuseReason, time.Duration(u.lobby.cfg.AbuseBanDuration))
+		abuseBans.Inc()
+		u.logger().Warn("banning IP address for abuse", "ip", ip, "until", b.Until)
+	}
+	for _, o := range u.lobby.bannedUsers(b) {
+		if o != u {
+			go o.kick(b.Reason)
+		}
+	}
+	u.kick(b.Reason)
+}
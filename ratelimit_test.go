@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/config"
+)
+
+func TestMessageQuota(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.MessageQuotas = []string{"prepNew:1/1m"}
+	})
+	alice := s.login("alice")
+	bob := s.login("bob")
+
+	alice.send("prepNew", nil)
+	alice.expectPrepUpdate(true, true, "alice")
+	alice.send("prepLeave", nil)
+	alice.expect("prep")
+
+	alice.send("prepNew", nil)
+	body := alice.expectError("rateLimited")
+	if body.RetryAfterMs <= 0 || body.RetryAfterMs > 60000 {
+		t.Errorf("got retryAfterMs %d, want up to a minute", body.RetryAfterMs)
+	}
+	alice.expectNothing()
+
+	// The quota is per user.
+	bob.send("prepNew", nil)
+	bob.expectPrepUpdate(true, true, "bob")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/store"
+)
+
+// openGameStore starts recording games to the configured file.
+func (l *Lobby) openGameStore() error {
+	if l.cfg.GameStorePath == "" {
+		return nil
+	}
+	s, err := store.OpenFile(l.cfg.GameStorePath)
+	if err != nil {
+		return err
+	}
+	l.store = s
+	return nil
+}
+
+// restoreGames brings back the games that were running when the previous
+// process stopped. The snapshot saved on a graceful shutdown goes first, as it
+// also keeps the players who were not in the game; the game store then
+// rebuilds the games that only it knows about.
+func (l *Lobby) restoreGames() {
+	err := l.restoreSnapshot()
+	if err != nil {
+		slog.Error("cannot restore saved games", "path", l.cfg.SnapshotPath, "err", err)
+	}
+	l.recoverGames()
+}
+
+// recoverGames rebuilds the unfinished games of the game store, but for those
+// already restored from the snapshot.
+func (l *Lobby) recoverGames() {
+	for _, g := range l.store.Unfinished() {
+		if r := l.findRoomById(g.RoomId); r != nil && r.currentGameId() == g.Id {
+			slog.Debug("game was restored from the snapshot", "game", g.Id)
+			continue
+		}
+		err := l.recoverGame(g)
+		if errors.Is(err, game.ErrGameOver) {
+			slog.Info("recovered game was already over", "game", g.Id)
+			l.store.Finish(g.Id)
+			continue
+		}
+		if err != nil {
+			slog.Error("cannot recover game", "game", g.Id, "err", err)
+			continue
+		}
+		slog.Info("recovered game", "game", g.Id, "room", g.RoomId, "events", len(g.Events))
+	}
+}
+
+func (l *Lobby) recoverGame(g store.Game) error {
+	if l.findRoomById(g.RoomId) != nil {
+		return errors.New("the room already exists")
+	}
+	kind, err := lookupGame(g.Name)
+	if err != nil {
+		return err
+	}
+	players := make([]roomPlayerSnapshot, 0, len(g.Setup.Usernames))
+	for _, username := range g.Setup.Usernames {
+		players = append(players, roomPlayerSnapshot{
+			Username: username,
+			Session:  g.Sessions[username],
+			IsInGame: true,
+		})
+	}
+	r := l.reservedRoom(g.RoomId, g.Id, kind, g.Setup.IndexRuleSet, players)
+	r.allowUndo = g.Setup.AllowUndo
+	r.finishRound = g.Setup.FinishRound
+	r.tieBreakers = g.Setup.TieBreakers
+
+	setup := g.Setup
+	setup.Journal = r.journal(g.Id)
+	setup.Logger = r.logger().With("game", g.Id)
+	toGame, fromGame, err := kind.Replay(setup, g.Events)
+	if err != nil {
+		return err
+	}
+	l.addRestoredRoom(r, toGame, fromGame)
+	return nil
+}
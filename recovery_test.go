@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cantstop "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/cant_stop"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/config"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+// errorCounter counts the errors logged while it is the default handler.
+type errorCounter struct {
+	slog.Handler
+	errors *atomic.Int64
+}
+
+func (h errorCounter) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelError
+}
+
+func (h errorCounter) Handle(context.Context, slog.Record) error {
+	h.errors.Add(1)
+	return nil
+}
+
+func (h errorCounter) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func TestSnapshotAndStoreRestoreEachGameOnce(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.SnapshotPath = filepath.Join(dir, "snapshot.json")
+		cfg.GameStorePath = filepath.Join(dir, "games.jsonl")
+	})
+	err := s.lobby.openGameStore()
+	if err != nil {
+		t.Fatalf("openGameStore: %s", err)
+	}
+	alice, bob := s.login("alice"), s.login("bob")
+	carol, dave := s.login("carol"), s.login("dave")
+	saved := s.newRoom(cantstop.Name, 4, alice, bob)
+	crashed := s.newRoom(cantstop.Name, 4, carol, dave)
+	alice.send("start", nil)
+	carol.send("start", nil)
+	alice.skipTo("start")
+	carol.skipTo("start")
+	gameIds := map[string]string{}
+	for _, id := range []string{saved, crashed} {
+		gameIds[id] = s.lobby.findRoomById(id).currentGameId()
+	}
+
+	s.lobby.shutdown(context.Background())
+	s.lobby.store.Close()
+
+	// Only the first game made it into the snapshot; the store has both.
+	cfg := s.lobby.cfg
+	data, err := os.ReadFile(cfg.SnapshotPath)
+	if err != nil {
+		t.Fatalf("reading snapshot: %s", err)
+	}
+	snapshot := lobbySnapshot{}
+	json.Unmarshal(data, &snapshot)
+	for _, rs := range snapshot.Rooms {
+		if rs.Id == saved {
+			snapshot.Rooms = []roomSnapshot{rs}
+			break
+		}
+	}
+	err = writeSnapshot(cfg.SnapshotPath, snapshot)
+	if err != nil {
+		t.Fatalf("writing snapshot: %s", err)
+	}
+
+	errs := &atomic.Int64{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(errorCounter{errors: errs}))
+	l := initializeLobby(cfg)
+	err = l.openGameStore()
+	if err != nil {
+		t.Fatalf("openGameStore: %s", err)
+	}
+	defer l.store.Close()
+	l.restoreGames()
+
+	if errs.Load() != 0 {
+		t.Errorf("got %d errors restoring the games", errs.Load())
+	}
+	if len(l.rooms) != 2 {
+		t.Fatalf("got %d rooms, want 2", len(l.rooms))
+	}
+	for id, gameId := range gameIds {
+		r := l.findRoomById(id)
+		if r == nil || r.currentGameId() != gameId {
+			t.Errorf("room %s was not restored with game %s", id, gameId)
+		}
+	}
+	if _, err := os.Stat(cfg.SnapshotPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("snapshot was not removed after restoring it: %v", err)
+	}
+}
+
+func TestReservedSeatNeedsSession(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.ResumeGrace = config.Duration(time.Millisecond)
+	})
+	r := s.lobby.reservedRoom("RESERVED", "", s.lobby.defaultGame, 4, []roomPlayerSnapshot{
+		{Username: "alice", Session: "alice-session"},
+		{Username: "bob", Session: "bob-session"},
+	})
+	s.lobby.mu.Lock()
+	s.lobby.rooms = append(s.lobby.rooms, r)
+	s.lobby.mu.Unlock()
+
+	impostor := s.login("alice")
+	impostor.send("prepJoin", protocol.PrepJoinBody{RoomId: "RESERVED"})
+	impostor.expectError("")
+	impostor.expect("prep")
+	impostor.conn.Close()
+	s.waitFor("impostor to leave", func() bool { return s.lobby.findUserByUsername("alice") == nil })
+
+	alice := s.dial("alice")
+	alice.send("resume", protocol.ResumeBody{Session: "alice-session"})
+	alice.expect("version", "session")
+	body := decode[protocol.PrepUpdateBody](t, alice.skipTo("prepUpdate"))
+	if body.RoomId != "RESERVED" {
+		t.Errorf("got room %q after resuming, want RESERVED", body.RoomId)
+	}
+}
@@ -2,40 +2,78 @@ package main
 
 import (
 	"errors"
-	"log"
+	"log/slog"
 	"slices"
 	"sync"
+	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/store"
 )
 
+// mu guards every field of a Room but id, maxPlayers, hostDecidesPause and
+// store, which never change. It is never held while sending to the game or to
+// a user.
 type Room struct {
-	mu           *sync.RWMutex
-	id           string
-	players      []RoomPlayer
-	toGame       chan Data
-	fromGame     chan Data
-	indexRuleset int
+	mu               *sync.RWMutex
+	id               string
+	maxPlayers       int
+	hostDecidesPause bool
+	players          []RoomPlayer
+	toGame           chan Data
+	fromGame         chan Data
+	gameDone         chan struct{}
+	gameId           string
+	game             game.Game
+	indexRuleset     int
+	allowUndo        bool
+	finishRound      bool
+	tieBreakers      []string
+	paused           bool
+	motion           *motion
+	closed           bool
+	store            store.GameStore
 }
 
+// A RoomPlayer with a nil user is a seat in a restored game, reserved until
+// its player returns or the reservation expires.
 type RoomPlayer struct {
-	username string
-	toUser   chan Data
-	isReady  bool
-	isInGame bool
+	username   string
+	user       *User
+	session    string
+	reservedAt time.Time
+	isReady    bool
+	isInGame   bool
+}
+
+func (r *Room) logger() *slog.Logger {
+	return slog.With("room", r.id)
 }
 
 func (r *Room) addPlayer(u *User) error {
 	if u == nil {
-		log.Printf("addPlayer: received nil User")
+		r.logger().Error("addPlayer: received nil User")
 		return errors.New("received nil User")
 	}
-	if len(r.players) >= MaxNumUsersPerRoom {
-		return ErrTooManyUsersInRoom
+	claimed, err := r.claimReservation(u)
+	if claimed || err != nil {
+		return err
 	}
 
 	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return ErrRoomNotExist
+	}
+	if len(r.players) >= r.maxPlayers {
+		r.mu.Unlock()
+		return ErrTooManyUsersInRoom
+	}
 	r.players = append(r.players, RoomPlayer{
 		username: u.username,
-		toUser:   u.toUser,
+		user:     u,
+		session:  u.session,
 		isReady:  false,
 		isInGame: false,
 	})
@@ -49,7 +87,8 @@ func (r *Room) removePlayer(username string) {
 	r.mu.Lock()
 	i := r.indexPlayer(username)
 	if i == -1 {
-		log.Printf("removePlayer: %s is already not in the room", username)
+		r.mu.Unlock()
+		r.logger().Warn("removePlayer: user is already not in the room", "user", username)
 		return
 	}
 	r.players = slices.Delete(r.players, i, i+1)
@@ -58,11 +97,36 @@ func (r *Room) removePlayer(username string) {
 	r.broadcastPrepUpdate()
 }
 
-func (r *Room) setIndexRuleset(i int) {
+// setRuleset picks the rule set of game g, or of the room's game if g is nil,
+// and changes the options that body sets. Rule set 0 is the game's default.
+func (r *Room) setRuleset(g game.Game, body protocol.RulesetBody) error {
 	r.mu.Lock()
+	if g == nil {
+		g = r.game
+	}
+	i := body.Ruleset
+	if i == 0 {
+		i = game.DefaultRuleset(g).Index
+	}
+	_, err := game.FindRuleset(g, i)
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	r.game = g
 	r.indexRuleset = i
+	if body.AllowUndo != nil {
+		r.allowUndo = *body.AllowUndo
+	}
+	if body.FinishRound != nil {
+		r.finishRound = *body.FinishRound
+	}
+	if body.TieBreakers != nil {
+		r.tieBreakers = slices.Clone(body.TieBreakers)
+	}
 	r.mu.Unlock()
 	r.broadcastPrepUpdate()
+	return nil
 }
 
 func (r *Room) setReady(username string) {
@@ -87,36 +151,56 @@ func (r *Room) setUnready(username string) {
 	r.broadcastPrepUpdate()
 }
 
-func (r Room) broadcastPrepUpdate() {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+func (r *Room) broadcastPrepUpdate() {
+	type update struct {
+		user *User
+		body protocol.PrepUpdateBody
+	}
 
+	r.mu.RLock()
+	updates := make([]update, 0, len(r.players))
+	usernames := r.playerNames()
+	allReady := r.allReady()
 	for i, p := range r.players {
 		if p.isInGame {
 			continue
 		}
-		data := Data{
-			Type: "prepUpdate",
-			Body: map[string]interface{}{
-				"roomId":    r.id,
-				"isHosting": false,
-				"isReady":   p.isReady,
-				"usernames": r.usernames(),
-				"ruleset":   r.indexRuleset,
-			},
+		body := protocol.PrepUpdateBody{
+			RoomId:      r.id,
+			IsHosting:   false,
+			IsReady:     p.isReady,
+			Usernames:   usernames,
+			Game:        r.game.Name(),
+			Ruleset:     r.indexRuleset,
+			AllowUndo:   r.allowUndo,
+			FinishRound: r.finishRound,
+			TieBreakers: r.tieBreakers,
 		}
 		if i == 0 {
-			data.Body["isHosting"] = true
-			data.Body["isReady"] = r.isAllReady()
+			body.IsHosting = true
+			body.IsReady = allReady
 		}
-		p.toUser <- data
+		updates = append(updates, update{user: p.user, body: body})
+	}
+	r.mu.RUnlock()
+
+	for _, up := range updates {
+		up.user.enqueue(Data{
+			Type: "prepUpdate",
+			Body: up.body,
+		})
 	}
 }
 
-func (r Room) usernames() []string {
+func (r *Room) usernames() []string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	return r.playerNames()
+}
+
+// playerNames must be called with r.mu held.
+func (r *Room) playerNames() []string {
 	result := make([]string, len(r.players))
 	for i, u := range r.players {
 		result[i] = u.username
@@ -124,10 +208,15 @@ func (r Room) usernames() []string {
 	return result
 }
 
-func (r Room) isAllReady() bool {
+func (r *Room) isAllReady() bool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	return r.allReady()
+}
+
+// allReady must be called with r.mu held.
+func (r *Room) allReady() bool {
 	for i, p := range r.players {
 		if i != 0 && !p.isReady {
 			return false
@@ -136,10 +225,33 @@ func (r Room) isAllReady() bool {
 	return true
 }
 
-func (r Room) indexPlayer(username string) int {
+func (r *Room) isHost(username string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.indexPlayer(username) == 0
+}
+
+// indexPlayer must be called with r.mu held.
+func (r *Room) indexPlayer(username string) int {
 	return slices.IndexFunc(r.players, func(p RoomPlayer) bool { return p.username == username })
 }
 
+func (r *Room) isInGame(username string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := r.indexPlayer(username)
+	return i != -1 && r.players[i].isInGame
+}
+
+func (r *Room) isEmpty() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.players) == 0
+}
+
 func (r *Room) exitGame(username string) {
 	r.mu.Lock()
 	for i, p := range r.players {
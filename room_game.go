@@ -1,24 +1,61 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"time"
 
-	cantstop "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/cant_stop"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/store"
 )
 
-func (r *Room) startGame() {
-	toGame, fromGame, err := cantstop.StartGameCantStop(r.indexRuleset, r.usernames())
+// startGame starts the game picked for the room if username may start it.
+// Checking and starting happen under one lock so that nobody joins or
+// becomes unready in between.
+func (r *Room) startGame(username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.canStart(username)
 	if err != nil {
-		log.Printf("error starting game: %s", err)
-		return
+		return err
 	}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	g := r.game
+	now := time.Now()
+	setup := game.Setup{
+		IndexRuleSet: r.indexRuleset,
+		Usernames:    r.playerNames(),
+		Seed:         now.UnixNano(),
+		StartedAt:    now,
+		AllowUndo:    r.allowUndo,
+		FinishRound:  r.finishRound,
+		TieBreakers:  r.tieBreakers,
+	}
+	gameId := fmt.Sprintf("%s-%d", r.id, setup.Seed)
+	err = r.store.Create(store.Game{
+		Id:       gameId,
+		Name:     g.Name(),
+		RoomId:   r.id,
+		Setup:    setup,
+		Sessions: r.playerSessions(),
+	})
+	if err != nil {
+		r.logger().Error("game will not be recoverable", "game", gameId, "err", err)
+	}
+	setup.Journal = r.journal(gameId)
+	setup.Logger = r.logger().With("game", gameId)
+
+	toGame, fromGame, err := g.Start(setup)
+	if err != nil {
+		r.logger().Error("cannot start game", "game", gameId, "err", err)
+		return err
+	}
 
 	r.toGame = toGame
 	r.fromGame = fromGame
-	log.Printf("Started")
+	r.gameDone = make(chan struct{})
+	r.gameId = gameId
+	r.logger().Info("game started", "game", gameId, "name", g.Name(), "users", setup.Usernames)
 
 	for i := range r.players {
 		r.players[i].isReady = false
@@ -26,31 +63,133 @@ func (r *Room) startGame() {
 	}
 
 	go r.forwardToUsers()
+	return nil
+}
+
+// canStart reports why username cannot start the game picked for the room
+// with its current players, if they cannot. It must be called with r.mu
+// held.
+func (r *Room) canStart(username string) error {
+	if r.indexPlayer(username) != 0 {
+		return ErrNotHost
+	}
+	if r.toGame != nil {
+		return ErrGameInProgress
+	}
+	if !r.allReady() {
+		return ErrNotAllReady
+	}
+	rs, err := game.FindRuleset(r.game, r.indexRuleset)
+	if err != nil {
+		return err
+	}
+	return rs.CheckPlayers(len(r.players))
+}
+
+func (r *Room) journal(gameId string) func(game.Event) {
+	return func(e game.Event) {
+		err := r.store.Append(gameId, e)
+		if err != nil {
+			r.logger().Error("cannot record game event", "game", gameId, "err", err)
+		}
+	}
+}
+
+// playerSessions must be called with r.mu held.
+func (r *Room) playerSessions() map[string]string {
+	result := make(map[string]string, len(r.players))
+	for _, p := range r.players {
+		result[p.username] = p.session
+	}
+	return result
 }
 
-func (r Room) forwardToGame(d Data) {
-	r.toGame <- d
+func (r *Room) forwardToGame(d Data) {
+	r.mu.RLock()
+	toGame, gameDone := r.toGame, r.gameDone
+	r.mu.RUnlock()
+
+	if toGame == nil {
+		r.logger().Debug("no game is running, dropped message", "user", d.Username, "type", d.Type)
+		return
+	}
+	select {
+	case toGame <- d:
+	case <-gameDone:
+		r.logger().Debug("game is over, dropped message", "user", d.Username, "type", d.Type)
+	}
 }
 
-func (r Room) forwardToUsers() {
+func (r *Room) forwardToUsers() {
 	for d := range r.fromGame {
 		if d.Type == "exit" {
 			r.exitGame(d.Username)
 			continue
 		}
 		if d.Type == "terminate" {
+			r.endGame()
 			return
 		}
-		if d.Username != "" {
-			for _, p := range r.players {
-				if p.username == d.Username {
-					p.toUser <- d
-				}
-			}
-		} else {
-			for _, p := range r.players {
-				p.toUser <- d
-			}
+		if body, ok := d.Body.(game.PausedBody); ok {
+			r.setPaused(body.Paused)
+		}
+		for _, u := range r.recipients(d.Username) {
+			u.enqueue(d)
+		}
+	}
+}
+
+// recipients returns the user named username, or every user in the room if
+// username is empty.
+func (r *Room) recipients(username string) []*User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]*User, 0, len(r.players))
+	for _, p := range r.players {
+		if username == "" || p.username == username {
+			result = append(result, p.user)
+		}
+	}
+	return result
+}
+
+// currentGame returns the game being played in the room, if any.
+func (r *Room) currentGame() (game.Game, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.game, r.toGame != nil
+}
+
+// currentGameId returns the id of the game being played in the room, or the
+// empty string.
+func (r *Room) currentGameId() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.gameId
+}
+
+func (r *Room) endGame() {
+	r.mu.Lock()
+	close(r.gameDone)
+	r.toGame = nil
+	r.fromGame = nil
+	gameId := r.gameId
+	r.gameId = ""
+	r.paused = false
+	r.motion = nil
+	for i := range r.players {
+		r.players[i].isInGame = false
+	}
+	r.mu.Unlock()
+
+	if gameId != "" {
+		err := r.store.Finish(gameId)
+		if err != nil {
+			r.logger().Error("cannot mark game as finished", "game", gameId, "err", err)
 		}
 	}
+	r.broadcastPrepUpdate()
 }
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	cantstop "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/cant_stop"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/pig"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+// nextOf returns the first message any of clients receives.
+func nextOf(t *testing.T, clients ...*testClient) (*testClient, testMessage) {
+	t.Helper()
+	cases := make([]reflect.SelectCase, 0, len(clients)+1)
+	for _, c := range clients {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.messages)})
+	}
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(time.After(testTimeout))})
+	i, v, _ := reflect.Select(cases)
+	if i == len(clients) {
+		t.Fatalf("no message after %s", testTimeout)
+	}
+	return clients[i], v.Interface().(testMessage)
+}
+
+// playCantStop plays until someone wins, always taking the first action
+// offered and stopping after it, and returns the winner. Every client has
+// received the standings and the winner message when it returns.
+func playCantStop(t *testing.T, clients ...*testClient) string {
+	t.Helper()
+	winner := ""
+	winners := 0
+	standings := map[*testClient][]cantstop.Standing{}
+	for winners < len(clients) {
+		c, m := nextOf(t, clients...)
+		switch m.Type {
+		case "roll":
+			c.send("roll", nil)
+		case "result":
+			body := decode[cantstop.ResultBody](t, m)
+			if body.Failed {
+				c.send("confirm", cantstop.ConfirmBody{})
+				continue
+			}
+			// Some groupings of the dice may offer no action.
+			var action []int8
+			for _, o := range body.Options {
+				if len(o.Actions) != 0 {
+					action = o.Actions[0]
+					break
+				}
+			}
+			c.send("act", cantstop.ActBody{Action: action})
+		case "confirm":
+			willContinue := false
+			c.send("confirm", cantstop.ConfirmBody{WillContinue: &willContinue})
+		case "standings":
+			standings[c] = decode[cantstop.StandingsBody](t, m).Standings
+		case "winner":
+			body := decode[game.WinnerBody](t, m)
+			if winner != "" && body.Winner != winner {
+				t.Fatalf("%s: got winner %s, another client got %s", c.name, body.Winner, winner)
+			}
+			if len(standings[c]) != len(clients) || standings[c][0].Username != body.Winner {
+				t.Fatalf("%s: got winner %s after standings %+v", c.name, body.Winner, standings[c])
+			}
+			winner = body.Winner
+			winners++
+		case "error":
+			t.Fatalf("%s: got error %s", c.name, m.Body)
+		}
+	}
+	return winner
+}
+
+func TestCantStopGameStart(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	s.newRoom(cantstop.Name, 4, alice, bob)
+
+	alice.send("start", nil)
+	start := decode[cantstop.StartBody](t, alice.expect("start")[0])
+	if len(start.Usernames) != 2 {
+		t.Fatalf("got players %v, want alice and bob", start.Usernames)
+	}
+	first := s.clientNamed(start.Usernames[0], alice, bob)
+	second := s.clientNamed(start.Usernames[1], alice, bob)
+	bob.expect("start")
+
+	first.expect("log", "turnCount", "log", "player", "moveCount", "roll")
+	second.expect("log", "turnCount", "log", "player", "moveCount")
+	second.expectNothing()
+
+	second.send("roll", nil)
+	second.expectNothing()
+
+	first.send("roll", nil)
+	result := decode[cantstop.ResultBody](t, first.expect("log", "result")[1])
+	if len(result.Points) != 4 {
+		t.Fatalf("got %d dice, want 4", len(result.Points))
+	}
+	second.expect("log")
+	second.expectNothing()
+}
+
+func (s *testServer) clientNamed(name string, clients ...*testClient) *testClient {
+	s.t.Helper()
+	for _, c := range clients {
+		if c.name == name {
+			return c
+		}
+	}
+	s.t.Fatalf("no client named %s", name)
+	return nil
+}
+
+func TestCantStopGameToTheEnd(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	carol := s.login("carol")
+	s.newRoom(cantstop.Name, 2, alice, bob, carol)
+
+	alice.send("start", nil)
+	winner := playCantStop(t, alice, bob, carol)
+	if winner == "" {
+		t.Fatal("got no winner")
+	}
+}
+
+func TestExitAfterWin(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	s.newRoom(cantstop.Name, 2, alice, bob)
+
+	alice.send("start", nil)
+	playCantStop(t, alice, bob)
+
+	// Whoever leaves the game first waits in the room for the other.
+	bob.send("exit", nil)
+	bob.expectPrepUpdate(false, false, "alice", "bob")
+	bob.expectNothing()
+	alice.expectNothing()
+
+	// The last one to leave ends the game, which updates the room again.
+	alice.send("exit", nil)
+	for range 2 {
+		alice.expectPrepUpdate(true, false, "alice", "bob")
+		bob.expectPrepUpdate(false, false, "alice", "bob")
+	}
+
+	bob.send("prepReady", nil)
+	alice.expectPrepUpdate(true, true, "alice", "bob")
+	bob.expectPrepUpdate(false, true, "alice", "bob")
+	alice.send("start", nil)
+	alice.expect("start")
+	bob.expect("start")
+}
+
+func TestPigGameToTheEnd(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	s.newRoom(pig.Name, 2, alice, bob)
+
+	alice.send("start", nil)
+	winner := ""
+	for winner == "" {
+		c, m := nextOf(t, alice, bob)
+		switch m.Type {
+		case "pigState":
+			body := decode[pig.PigStateBody](t, m)
+			if body.Ended || body.Playing != c.name {
+				continue
+			}
+			if body.TurnTotal >= 20 {
+				c.send("hold", nil)
+			} else {
+				c.send("roll", nil)
+			}
+		case "winner":
+			winner = decode[game.WinnerBody](t, m).Winner
+		case "error":
+			t.Fatalf("%s: got error %s", c.name, m.Body)
+		}
+	}
+}
+
+func TestCantStopUndo(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	s.newRoom(cantstop.Name, 4, alice, bob)
+	allowUndo := true
+	alice.send("ruleset", protocol.RulesetBody{Ruleset: 4, AllowUndo: &allowUndo})
+	if !alice.expectPrepUpdate(true, true, "alice", "bob").AllowUndo {
+		t.Fatal("undo is not allowed after allowing it")
+	}
+	bob.expect("prepUpdate")
+
+	alice.send("start", nil)
+	start := decode[cantstop.StartBody](t, alice.skipTo("start"))
+	first := s.clientNamed(start.Usernames[0], alice, bob)
+	second := s.clientNamed(start.Usernames[1], alice, bob)
+	first.skipTo("roll")
+	first.send("roll", nil)
+	result := decode[cantstop.ResultBody](t, first.skipTo("result"))
+	if result.Failed {
+		t.Fatal("the first roll offered no action")
+	}
+	action := result.Options[0].Actions[0]
+	first.send("act", cantstop.ActBody{Action: action})
+	if !decode[cantstop.ConfirmPromptBody](t, first.skipTo("confirm")).CanUndo {
+		t.Fatal("confirm does not offer undo")
+	}
+
+	first.send("undo", nil)
+	for _, c := range []*testClient{first, second} {
+		undo := decode[cantstop.UndoBody](t, c.skipTo("undo"))
+		if undo.Username != first.name || !slices.Equal(undo.Action, action) {
+			t.Fatalf("%s: got undo of %v by %s, want %v by %s", c.name, undo.Action, undo.Username, action, first.name)
+		}
+	}
+	again := decode[cantstop.ResultBody](t, first.expect("log", "result")[1])
+	if !reflect.DeepEqual(again, result) {
+		t.Fatalf("got result %+v after undo, want %+v", again, result)
+	}
+	second.expect("log")
+	second.expectNothing()
+}
+
+func TestCantStopFinishRound(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	s.newRoom(cantstop.Name, 4, alice, bob)
+	finishRound := true
+	alice.send("ruleset", protocol.RulesetBody{Ruleset: 4, FinishRound: &finishRound, TieBreakers: []string{"luck"}})
+	body := alice.expectPrepUpdate(true, true, "alice", "bob")
+	if !body.FinishRound || !slices.Equal(body.TieBreakers, []string{"luck"}) {
+		t.Fatalf("got finishRound %t and tie-breakers %v", body.FinishRound, body.TieBreakers)
+	}
+	bob.expect("prepUpdate")
+	alice.send("start", nil)
+	alice.expectError("invalidRuleset")
+	alice.expect("prepUpdate")
+	bob.expect("prepUpdate")
+
+	alice.send("ruleset", protocol.RulesetBody{Ruleset: 4, TieBreakers: []string{"moves"}})
+	body = alice.expectPrepUpdate(true, true, "alice", "bob")
+	if !body.FinishRound || !slices.Equal(body.TieBreakers, []string{"moves"}) {
+		t.Fatalf("got finishRound %t and tie-breakers %v", body.FinishRound, body.TieBreakers)
+	}
+	bob.expect("prepUpdate")
+	alice.send("start", nil)
+	start := decode[cantstop.StartBody](t, alice.skipTo("start"))
+	playCantStop(t, alice, bob)
+
+	// The last to play is the second player, whoever won.
+	last := s.clientNamed(start.Usernames[1], alice, bob)
+	last.send("resync", nil)
+	standings := decode[cantstop.StandingsBody](t, last.skipTo("standings")).Standings
+	if len(standings) != 2 || standings[0].Rank != 1 {
+		t.Fatalf("got standings %+v after resync", standings)
+	}
+}
+
+func TestLegacyGameboard(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice", protocol.Version)
+	bob := s.login("bob", 1)
+	s.newRoom(cantstop.Name, 4, alice, bob)
+
+	alice.send("start", nil)
+	start := decode[cantstop.StartBody](t, alice.skipTo("start"))
+	first := s.clientNamed(start.Usernames[0], alice, bob)
+	first.skipTo("roll")
+	first.send("roll", nil)
+	result := decode[cantstop.ResultBody](t, first.skipTo("result"))
+	first.send("act", cantstop.ActBody{Action: result.Options[0].Actions[0]})
+
+	delta := decode[cantstop.GameboardDeltaBody](t, alice.skipTo("gameboardDelta"))
+	board := decode[cantstop.GameboardBody](t, bob.skipTo("gameboard"))
+	if board.Seq != delta.Seq {
+		t.Fatalf("got gameboard %d for version 1, want %d", board.Seq, delta.Seq)
+	}
+	temp := 0
+	for _, path := range board.Gameboard {
+		for _, space := range path {
+			if space.HasTemp {
+				temp++
+			}
+		}
+	}
+	if temp == 0 {
+		t.Fatalf("got gameboard %+v without the markers just placed", board.Gameboard)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	cantstop "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/cant_stop"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/config"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+func TestNewRoom(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+
+	alice.send("prepNew", nil)
+	body := alice.expectPrepUpdate(true, true, "alice")
+	if len(body.RoomId) != 8 {
+		t.Errorf("got room id %q, want 8 characters", body.RoomId)
+	}
+	if body.Game != defaultGameName {
+		t.Errorf("got game %q, want %q", body.Game, defaultGameName)
+	}
+
+	bob.send("prepJoin", protocol.PrepJoinBody{RoomId: body.RoomId})
+	alice.expectPrepUpdate(true, false, "alice", "bob")
+	bob.expectPrepUpdate(false, false, "alice", "bob")
+}
+
+func TestReadyUnready(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	carol := s.login("carol")
+	s.newRoom("cantstop", 4, alice, bob, carol)
+
+	bob.send("prepUnready", nil)
+	alice.expectPrepUpdate(true, false, "alice", "bob", "carol")
+	bob.expectPrepUpdate(false, false, "alice", "bob", "carol")
+	carol.expectPrepUpdate(false, true, "alice", "bob", "carol")
+
+	alice.send("start", nil)
+	alice.expectPrepUpdate(true, false, "alice", "bob", "carol")
+	bob.expect("prepUpdate")
+	carol.expect("prepUpdate")
+
+	bob.send("prepReady", nil)
+	alice.expectPrepUpdate(true, true, "alice", "bob", "carol")
+	bob.expectPrepUpdate(false, true, "alice", "bob", "carol")
+	carol.expectPrepUpdate(false, true, "alice", "bob", "carol")
+}
+
+func TestOnlyHostStarts(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	s.newRoom("cantstop", 4, alice, bob)
+
+	bob.send("start", nil)
+	alice.expectPrepUpdate(true, true, "alice", "bob")
+	bob.expectPrepUpdate(false, true, "alice", "bob")
+	alice.expectNothing()
+}
+
+func TestRulesetMustExist(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	s.newRoom("cantstop", 3, alice, bob)
+
+	alice.send("ruleset", protocol.RulesetBody{Game: "pig", Ruleset: 3})
+	alice.expectError("invalidRuleset")
+	body := alice.expectPrepUpdate(true, true, "alice", "bob")
+	if body.Game != "cantstop" || body.Ruleset != 3 {
+		t.Errorf("got %s rule set %d, want cantstop rule set 3", body.Game, body.Ruleset)
+	}
+	bob.expect("prepUpdate")
+
+	alice.send("ruleset", protocol.RulesetBody{Game: "pig"})
+	body = alice.expectPrepUpdate(true, true, "alice", "bob")
+	if body.Game != "pig" || body.Ruleset != 1 {
+		t.Errorf("got %s rule set %d, want pig rule set 1", body.Game, body.Ruleset)
+	}
+	bob.expect("prepUpdate")
+	alice.send("start", nil)
+	alice.expect("log", "log", "pigState")
+	bob.expect("log", "log", "pigState")
+}
+
+func TestNewRoomHasDefaultRuleset(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+
+	alice.send("prepNew", nil)
+	body := alice.expectPrepUpdate(true, true, "alice")
+	if body.Game != "cantstop" || body.Ruleset != 4 {
+		t.Errorf("got %s rule set %d, want cantstop rule set 4", body.Game, body.Ruleset)
+	}
+}
+
+func TestStartChecksPlayerCount(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	s.newRoom("pig", 1, alice)
+
+	alice.send("start", nil)
+	alice.expectError("playerCount")
+	alice.expect("prepUpdate")
+}
+
+func TestUnknownGame(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	s.newRoom("cantstop", 4, alice)
+
+	alice.send("ruleset", protocol.RulesetBody{Game: "chess", Ruleset: 1})
+	alice.expectError("unknownGame")
+	body := alice.expectPrepUpdate(true, true, "alice")
+	if body.Game != "cantstop" || body.Ruleset != 4 {
+		t.Errorf("got %s rule set %d, want cantstop rule set 4", body.Game, body.Ruleset)
+	}
+}
+
+func TestHostLeaves(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	carol := s.login("carol")
+	id := s.newRoom("cantstop", 4, alice, bob, carol)
+
+	alice.send("prepLeave", nil)
+	alice.expect("prep")
+	bob.expectPrepUpdate(true, true, "bob", "carol")
+	carol.expectPrepUpdate(false, true, "bob", "carol")
+
+	carol.send("start", nil)
+	bob.expect("prepUpdate")
+	carol.expect("prepUpdate")
+	bob.send("start", nil)
+	bob.expect("start")
+	carol.expect("start")
+
+	// Players in a game only hear about the room once they leave the game.
+	alice.send("prepJoin", protocol.PrepJoinBody{RoomId: id})
+	alice.expectPrepUpdate(false, false, "bob", "carol", "alice")
+}
+
+func TestHostDisconnects(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.EnableSessionResume = false
+	})
+	alice := s.login("alice")
+	bob := s.login("bob")
+	s.newRoom("cantstop", 4, alice, bob)
+
+	alice.conn.Close()
+	bob.expectPrepUpdate(true, true, "bob")
+}
+
+// TestConcurrentJoinLeave has guests join, ready, unready and leave a room
+// at once while its host changes the rule set, and some of the guests drop
+// their connections halfway. In the end only the host is left.
+func TestConcurrentJoinLeave(t *testing.T) {
+	const numGuests, rounds = 6, 20
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.EnableSessionResume = false
+		cfg.MaxNumUsersPerRoom = numGuests + 1
+		cfg.SendQueueSize = 1024
+	})
+	host := s.login("host")
+	id := s.newRoom("cantstop", 4, host)
+	guests := make([]*testClient, numGuests)
+	for i := range guests {
+		guests[i] = s.login(fmt.Sprintf("guest%d", i))
+	}
+
+	for n := 0; n < rounds; n++ {
+		for i, g := range guests {
+			if i%2 == 1 && n == rounds/2 {
+				g.conn.Close()
+			}
+			if i%2 == 1 && n >= rounds/2 {
+				continue
+			}
+			g.send("prepJoin", protocol.PrepJoinBody{RoomId: id})
+			g.send("prepReady", nil)
+			g.send("prepUnready", nil)
+			g.send("prepLeave", nil)
+		}
+		host.send("ruleset", protocol.RulesetBody{Ruleset: n%4 + 1})
+	}
+	for i, g := range guests {
+		if i%2 == 1 {
+			continue
+		}
+		for n := 0; n < rounds; n++ {
+			g.skipTo("prep")
+		}
+	}
+
+	r := s.lobby.findRoomById(id)
+	if r == nil {
+		t.Fatal("room was deleted while its host was in it")
+	}
+	s.waitFor("only the host is left", func() bool {
+		return slices.Equal(r.usernames(), []string{"host"})
+	})
+	if !r.isAllReady() {
+		t.Error("host alone is not ready")
+	}
+}
+
+func TestResync(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	alice.send("resync", nil)
+	alice.expect("prep")
+
+	bob := s.login("bob")
+	s.newRoom(cantstop.Name, 4, alice, bob)
+	bob.send("resync", nil)
+	bob.expectPrepUpdate(false, true, "alice", "bob")
+	alice.expectPrepUpdate(true, true, "alice", "bob")
+
+	alice.send("start", nil)
+	start := decode[cantstop.StartBody](t, alice.skipTo("start"))
+	first := s.clientNamed(start.Usernames[0], alice, bob)
+	second := s.clientNamed(start.Usernames[1], alice, bob)
+	first.skipTo("roll")
+	second.skipTo("moveCount")
+	first.send("resync", nil)
+	first.expect("start", "gameboard", "turnCount", "player", "player", "moveCount", "roll")
+	second.expectNothing()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+const (
+	votePassed   = "passed"
+	voteRejected = "rejected"
+)
+
+// A motion is a vote in progress to pause, unpause or abort the game. It
+// passes once every player still in the game agrees, or, unless it is an
+// abort, as soon as the host does if hostDecidesPause is set. A single
+// player against it rejects it.
+type motion struct {
+	name  string
+	votes []string
+}
+
+// vote records username's vote and tells the players in the game where the
+// vote stands. A motion that passes is sent to the game, which announces its
+// outcome.
+func (r *Room) vote(username string, body protocol.VoteBody) error {
+	r.mu.Lock()
+	update, err := r.castVote(username, body)
+	recipients := r.gamePlayers()
+	r.mu.Unlock()
+	if err != nil || update == nil {
+		return err
+	}
+
+	for _, u := range recipients {
+		u.enqueue(Data{
+			Type: "voteUpdate",
+			Body: *update,
+		})
+	}
+	if update.Result == votePassed {
+		r.logger().Info("vote passed", "motion", update.Motion, "votes", update.Votes)
+		r.forwardToGame(Data{
+			Username: username,
+			Type:     update.Motion,
+		})
+	}
+	return nil
+}
+
+// castVote must be called with r.mu held. It returns nil if username voted
+// against a motion nobody had proposed.
+func (r *Room) castVote(username string, body protocol.VoteBody) (*protocol.VoteUpdateBody, error) {
+	i := r.indexPlayer(username)
+	if r.toGame == nil || i == -1 || !r.players[i].isInGame {
+		return nil, ErrNotInGame
+	}
+	if body.Motion == game.InputPause && r.paused || body.Motion == game.InputUnpause && !r.paused {
+		return nil, ErrInvalidMotion
+	}
+	if r.motion != nil && r.motion.name != body.Motion {
+		return nil, ErrVotePending
+	}
+
+	if !body.Agree {
+		if r.motion == nil {
+			return nil, nil
+		}
+		update := r.voteUpdate(voteRejected)
+		r.motion = nil
+		return &update, nil
+	}
+	if r.motion == nil {
+		r.motion = &motion{name: body.Motion}
+	}
+	if !slices.Contains(r.motion.votes, username) {
+		r.motion.votes = append(r.motion.votes, username)
+	}
+	result := ""
+	if r.motionPassed(i) {
+		result = votePassed
+	}
+	update := r.voteUpdate(result)
+	if result != "" {
+		r.motion = nil
+	}
+	return &update, nil
+}
+
+// motionPassed must be called with r.mu held, just after the player at index
+// i voted for r.motion.
+func (r *Room) motionPassed(i int) bool {
+	if i == 0 && r.hostDecidesPause && r.motion.name != game.InputAbort {
+		return true
+	}
+	for _, username := range r.voters() {
+		if !slices.Contains(r.motion.votes, username) {
+			return false
+		}
+	}
+	return true
+}
+
+// voteUpdate must be called with r.mu held.
+func (r *Room) voteUpdate(result string) protocol.VoteUpdateBody {
+	return protocol.VoteUpdateBody{
+		Motion: r.motion.name,
+		Votes:  slices.Clone(r.motion.votes),
+		Voters: r.voters(),
+		Result: result,
+	}
+}
+
+// voters must be called with r.mu held.
+func (r *Room) voters() []string {
+	result := []string{}
+	for _, p := range r.players {
+		if p.isInGame && p.user != nil {
+			result = append(result, p.username)
+		}
+	}
+	return result
+}
+
+// gamePlayers must be called with r.mu held.
+func (r *Room) gamePlayers() []*User {
+	result := []*User{}
+	for _, p := range r.players {
+		if p.isInGame && p.user != nil {
+			result = append(result, p.user)
+		}
+	}
+	return result
+}
+
+// setPaused follows the game as it is paused and unpaused. Reserved seats get
+// their whole timeout again once the game goes on.
+func (r *Room) setPaused(paused bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.paused && !paused {
+		now := time.Now()
+		for i, p := range r.players {
+			if p.user == nil && !p.reservedAt.IsZero() {
+				r.players[i].reservedAt = now
+			}
+		}
+	}
+	r.paused = paused
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/config"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/pig"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+// startPig starts a game of Pig between alice, the host, and bob, and
+// returns the client whose turn it is.
+func startPig(s *testServer, alice, bob *testClient) *testClient {
+	s.t.Helper()
+	s.newRoom(pig.Name, 1, alice, bob)
+	alice.send("start", nil)
+	state := decode[pig.PigStateBody](s.t, alice.expect("log", "log", "pigState")[2])
+	bob.expect("log", "log", "pigState")
+	return s.clientNamed(state.Playing, alice, bob)
+}
+
+func (c *testClient) vote(motion string, agree bool) {
+	c.t.Helper()
+	c.send("vote", protocol.VoteBody{Motion: motion, Agree: agree})
+}
+
+func (c *testClient) expectVoteUpdate(result string, votes ...string) {
+	c.t.Helper()
+	body := decode[protocol.VoteUpdateBody](c.t, c.expect("voteUpdate")[0])
+	if body.Result != result || !slices.Equal(body.Votes, votes) {
+		c.t.Fatalf("%s: got vote to %s %q with votes %v, want %q with votes %v", c.name, body.Motion, body.Result, body.Votes, result, votes)
+	}
+}
+
+func (c *testClient) expectPaused(paused bool) {
+	c.t.Helper()
+	body := decode[game.PausedBody](c.t, c.expect("paused")[0])
+	if body.Paused != paused {
+		c.t.Fatalf("%s: got paused %t, want %t", c.name, body.Paused, paused)
+	}
+}
+
+func TestHostPauses(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	playing := startPig(s, alice, bob)
+	waiting := alice
+	if playing == alice {
+		waiting = bob
+	}
+
+	alice.vote(game.InputPause, true)
+	for _, c := range []*testClient{alice, bob} {
+		c.expectVoteUpdate("passed", "alice")
+		c.expectPaused(true)
+		c.expect("log")
+	}
+
+	playing.send("roll", nil)
+	playing.expectPaused(true)
+	waiting.expectNothing()
+	bob.vote(game.InputPause, true)
+	bob.expectError("invalidVote")
+
+	alice.vote(game.InputUnpause, true)
+	for _, c := range []*testClient{alice, bob} {
+		c.expectVoteUpdate("passed", "alice")
+		c.expectPaused(false)
+		c.expect("log")
+	}
+	playing.send("roll", nil)
+	playing.skipTo("pigState")
+	waiting.skipTo("pigState")
+}
+
+func TestPauseNeedsEveryone(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.HostDecidesPause = false
+	})
+	alice := s.login("alice")
+	bob := s.login("bob")
+	startPig(s, alice, bob)
+
+	bob.vote(game.InputPause, true)
+	alice.expectVoteUpdate("", "bob")
+	bob.expectVoteUpdate("", "bob")
+	alice.vote(game.InputAbort, true)
+	alice.expectError("invalidVote")
+	alice.vote(game.InputPause, false)
+	alice.expectVoteUpdate("rejected", "bob")
+	bob.expectVoteUpdate("rejected", "bob")
+
+	alice.vote(game.InputPause, true)
+	alice.expectVoteUpdate("", "alice")
+	bob.expectVoteUpdate("", "alice")
+	bob.vote(game.InputPause, true)
+	for _, c := range []*testClient{alice, bob} {
+		c.expectVoteUpdate("passed", "alice", "bob")
+		c.expectPaused(true)
+	}
+}
+
+func TestAbortVote(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	startPig(s, alice, bob)
+
+	// The host alone cannot abort the game.
+	alice.vote(game.InputAbort, true)
+	alice.expectVoteUpdate("", "alice")
+	bob.expectVoteUpdate("", "alice")
+	bob.vote(game.InputAbort, true)
+	for _, c := range []*testClient{alice, bob} {
+		c.expectVoteUpdate("passed", "alice", "bob")
+		c.expect("log")
+	}
+	alice.expectPrepUpdate(true, false, "alice", "bob")
+	bob.expectPrepUpdate(false, false, "alice", "bob")
+
+	alice.vote(game.InputPause, true)
+	alice.expectError("invalidVote")
+	bob.expectNothing()
+}
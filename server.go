@@ -2,66 +2,141 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/gorilla/websocket"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/origin"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
 )
 
-func initializeServer(addr *string, l *Lobby) *http.Server {
+func initializeServer(l *Lobby) (*http.Server, error) {
+	origins, err := origin.NewPolicy(l.cfg.AllowedOrigins, l.cfg.DevMode)
+	if err != nil {
+		return nil, err
+	}
+	if l.cfg.DevMode {
+		slog.Warn("dev mode: accepting loopback origins and requests without an Origin header")
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/healthz", handlerReadiness)
-	mux.HandleFunc("/", l.handlerDefault)
+	mux.HandleFunc("/v1/healthz", l.handlerLiveness)
+	mux.HandleFunc("/v1/livez", l.handlerLiveness)
+	mux.HandleFunc("/v1/readyz", l.handlerReadiness)
+	mux.Handle("/v1/schema", withCORS(origins, http.HandlerFunc(handlerSchema)))
+	mux.Handle("/v1/games", withCORS(origins, http.HandlerFunc(handlerGames)))
+	if l.cfg.EnableQueueStats {
+		mux.HandleFunc("/v1/queues", l.handlerQueues)
+	}
+	if l.cfg.AdminToken != "" {
+		l.registerAdminRoutes(mux)
+	}
+	if l.cfg.EnableMetrics {
+		mux.HandleFunc("/metrics", l.handlerMetrics)
+	}
+	mux.HandleFunc("/", l.handlerDefault(origins))
 
 	return &http.Server{
-		Addr:    *addr,
+		Addr:    l.cfg.Addr,
 		Handler: mux,
-	}
+	}, nil
+}
+
+func withCORS(origins *origin.Policy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o := r.Header.Get("Origin")
+		if o != "" {
+			if !origins.Allowed(o) {
+				origins.Reject(o, r.RemoteAddr)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", o)
+			w.Header().Add("Vary", "Origin")
+		}
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
-func (l *Lobby) handlerDefault(w http.ResponseWriter, r *http.Request) {
+func (l *Lobby) handlerDefault(origins *origin.Policy) http.HandlerFunc {
 	upgrader := websocket.Upgrader{
+		Subprotocols: l.subprotocols(),
 		CheckOrigin: func(r *http.Request) bool {
-			origin := r.Header.Get("Origin")
-			return origin == "http://cant-stop.kuangyuwu.com"
+			return origins.Allowed(r.Header.Get("Origin"))
 		},
 	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if o := r.Header.Get("Origin"); !origins.Allowed(o) {
+			origins.Reject(o, r.RemoteAddr)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		l.upgrade(upgrader, w, r)
+	}
+}
 
+func (l *Lobby) upgrade(upgrader websocket.Upgrader, w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade request failed: %s\n", err)
+		slog.Warn("WebSocket upgrade request failed", "remote", r.RemoteAddr, "err", err)
 		return
 	}
 
-	u, err := l.createUser(conn)
+	codec, err := protocol.CodecFor(conn.Subprotocol())
 	if err != nil {
-		log.Printf("Error creating user: %s\n", err)
+		slog.Warn("cannot select codec", "remote", r.RemoteAddr, "err", err)
+		conn.Close()
+		return
+	}
+
+	u, err := l.createUser(conn, codec)
+	if err != nil {
+		slog.Warn("cannot create user", "remote", r.RemoteAddr, "err", err)
 		conn.Close()
 		return
 	}
 
 	go u.handleMessage()
 	go u.sendMessage()
-	log.Print("a user connected")
+	slog.Info("user connected", "remote", r.RemoteAddr, "codec", codec.Name())
 }
 
-func handlerReadiness(w http.ResponseWriter, r *http.Request) {
-	payload := struct {
-		Status string `json:"status"`
-	}{
-		Status: "ok",
+func (l *Lobby) subprotocols() []string {
+	if l.cfg.EnableBinaryProtocol {
+		return protocol.Subprotocols()
 	}
-	respondWithJSON(w, http.StatusOK, payload)
+	return []string{protocol.SubprotocolJSON}
+}
+
+func (l *Lobby) handlerQueues(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, l.queueStats())
+}
+
+func handlerSchema(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, protocol.Schema())
+}
+
+func respondWithError(w http.ResponseWriter, code int, msg string) {
+	respondWithJSON(w, code, protocol.ErrorBody{
+		Error: msg,
+	})
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	data, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON %s", err)
+		slog.Error("cannot marshal JSON", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(code)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	w.Write(data)
 }
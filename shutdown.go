@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+const snapshotFormatVersion = 1
+
+type lobbySnapshot struct {
+	Version int            `json:"version"`
+	SavedAt time.Time      `json:"savedAt"`
+	Rooms   []roomSnapshot `json:"rooms"`
+}
+
+// GameName is empty in snapshots saved before rooms could host other games
+// than Can't Stop.
+type roomSnapshot struct {
+	Id           string               `json:"id"`
+	GameId       string               `json:"gameId"`
+	GameName     string               `json:"gameName,omitempty"`
+	IndexRuleset int                  `json:"indexRuleset"`
+	AllowUndo    bool                 `json:"allowUndo,omitempty"`
+	FinishRound  bool                 `json:"finishRound,omitempty"`
+	TieBreakers  []string             `json:"tieBreakers,omitempty"`
+	Players      []roomPlayerSnapshot `json:"players"`
+	Game         json.RawMessage      `json:"game"`
+}
+
+type roomPlayerSnapshot struct {
+	Username string `json:"username"`
+	Session  string `json:"session"`
+	IsInGame bool   `json:"isInGame"`
+}
+
+func (l *Lobby) shutdown(ctx context.Context) {
+	l.mu.Lock()
+	l.draining = true
+	rooms := slices.Clone(l.rooms)
+	users := slices.Clone(l.users)
+	l.mu.Unlock()
+
+	restorable := l.cfg.SnapshotPath != ""
+	for _, u := range users {
+		u.sendMaintenance("The server is restarting for maintenance.", restorable)
+	}
+
+	if restorable {
+		snapshot := lobbySnapshot{
+			Version: snapshotFormatVersion,
+			SavedAt: time.Now(),
+			Rooms:   []roomSnapshot{},
+		}
+		for _, r := range rooms {
+			rs, ok := r.snapshot(ctx)
+			if ok {
+				snapshot.Rooms = append(snapshot.Rooms, rs)
+			}
+		}
+		err := writeSnapshot(l.cfg.SnapshotPath, snapshot)
+		if err != nil {
+			slog.Error("cannot save games", "path", l.cfg.SnapshotPath, "err", err)
+		} else {
+			slog.Info("saved games", "path", l.cfg.SnapshotPath, "games", len(snapshot.Rooms))
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, u := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u.closeForShutdown(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *Room) snapshot(ctx context.Context) (roomSnapshot, bool) {
+	r.mu.RLock()
+	toGame := r.toGame
+	rs := roomSnapshot{
+		Id:           r.id,
+		GameId:       r.gameId,
+		GameName:     r.game.Name(),
+		IndexRuleset: r.indexRuleset,
+		AllowUndo:    r.allowUndo,
+		FinishRound:  r.finishRound,
+		TieBreakers:  r.tieBreakers,
+		Players:      make([]roomPlayerSnapshot, 0, len(r.players)),
+	}
+	for _, p := range r.players {
+		rs.Players = append(rs.Players, roomPlayerSnapshot{
+			Username: p.username,
+			Session:  p.session,
+			IsInGame: p.isInGame,
+		})
+	}
+	r.mu.RUnlock()
+
+	if toGame == nil {
+		return roomSnapshot{}, false
+	}
+	timeout := time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	s, err := game.RequestSnapshot(toGame, timeout)
+	if err == nil {
+		rs.Game, err = json.Marshal(s)
+	}
+	if err != nil {
+		r.logger().Error("cannot snapshot game", "err", err)
+		return roomSnapshot{}, false
+	}
+	return rs, true
+}
+
+func (u *User) sendMaintenance(message string, restorable bool) {
+	u.enqueue(Data{
+		Type: "maintenance",
+		Body: protocol.MaintenanceBody{
+			Message:    message,
+			Restorable: restorable,
+		},
+	})
+}
+
+func (u *User) closeForShutdown(ctx context.Context) {
+	deadline := time.Now().Add(time.Duration(u.lobby.cfg.WriteWait))
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	// Give the writer a chance to flush the maintenance notice.
+	for len(u.toUser) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	u.mu.Lock()
+	conn := u.conn
+	u.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting")
+	conn.WriteControl(websocket.CloseMessage, msg, deadline)
+	conn.Close()
+}
+
+func writeSnapshot(path string, snapshot lobbySnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// restoreSnapshot recreates the rooms saved by the previous process. Every
+// player gets a reserved seat they can reclaim by resuming their session.
+func (l *Lobby) restoreSnapshot() error {
+	if l.cfg.SnapshotPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(l.cfg.SnapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	snapshot := lobbySnapshot{}
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return err
+	}
+	if snapshot.Version != snapshotFormatVersion {
+		return errors.New("unsupported snapshot version")
+	}
+
+	for _, rs := range snapshot.Rooms {
+		err = l.restoreRoom(rs)
+		if err != nil {
+			slog.Error("cannot restore room", "room", rs.Id, "err", err)
+			continue
+		}
+		slog.Info("restored room", "room", rs.Id, "game", rs.GameId, "players", len(rs.Players))
+	}
+	return os.Remove(l.cfg.SnapshotPath)
+}
+
+func (l *Lobby) restoreRoom(rs roomSnapshot) error {
+	if l.findRoomById(rs.Id) != nil {
+		return errors.New("the room was already recovered")
+	}
+	g, err := lookupGame(rs.GameName)
+	if err != nil {
+		return err
+	}
+	r := l.reservedRoom(rs.Id, rs.GameId, g, rs.IndexRuleset, rs.Players)
+	r.allowUndo = rs.AllowUndo
+	r.finishRound = rs.FinishRound
+	r.tieBreakers = rs.TieBreakers
+	toGame, fromGame, err := g.Restore(rs.Game, r.journal(rs.GameId), r.logger().With("game", rs.GameId))
+	if err != nil {
+		return err
+	}
+	l.addRestoredRoom(r, toGame, fromGame)
+	return nil
+}
+
+// reservedRoom builds a room whose seats are all reserved for players of a
+// game that is about to be restored.
+func (l *Lobby) reservedRoom(id string, gameId string, g game.Game, indexRuleset int, players []roomPlayerSnapshot) *Room {
+	now := time.Now()
+	r := &Room{
+		mu:               &sync.RWMutex{},
+		id:               id,
+		maxPlayers:       l.cfg.MaxNumUsersPerRoom,
+		hostDecidesPause: l.cfg.HostDecidesPause,
+		players:          make([]RoomPlayer, 0, l.cfg.MaxNumUsersPerRoom),
+		gameDone:         make(chan struct{}),
+		gameId:           gameId,
+		game:             g,
+		indexRuleset:     indexRuleset,
+		store:            l.store,
+	}
+	for _, ps := range players {
+		r.players = append(r.players, RoomPlayer{
+			username:   ps.Username,
+			user:       nil,
+			session:    ps.Session,
+			reservedAt: now,
+			isInGame:   ps.IsInGame,
+		})
+	}
+	return r
+}
+
+func (l *Lobby) addRestoredRoom(r *Room, toGame, fromGame chan Data) {
+	r.toGame = toGame
+	r.fromGame = fromGame
+	s, err := game.RequestSnapshot(toGame, adminSnapshotTimeout)
+	if err == nil {
+		r.paused = s.Summary().Paused
+	}
+
+	l.mu.Lock()
+	l.rooms = append(l.rooms, r)
+	l.mu.Unlock()
+
+	go r.forwardToUsers()
+}
+
+// claimReservation gives u the seat reserved under its username, provided
+// u resumed the session the seat was reserved for. Anyone else who takes
+// the username gets ErrSeatReserved.
+func (r *Room) claimReservation(u *User) (bool, error) {
+	r.mu.Lock()
+	i := slices.IndexFunc(r.players, func(p RoomPlayer) bool {
+		return p.user == nil && p.username == u.username
+	})
+	if i == -1 {
+		r.mu.Unlock()
+		return false, nil
+	}
+	if r.players[i].session != u.session {
+		r.mu.Unlock()
+		r.logger().Warn("claimReservation: seat is reserved for another session", "user", u.username)
+		return false, ErrSeatReserved
+	}
+	r.players[i].user = u
+	r.players[i].reservedAt = time.Time{}
+	inGame := r.players[i].isInGame
+	r.mu.Unlock()
+
+	r.logger().Info("user reclaimed their seat", "user", u.username)
+	if inGame {
+		r.forwardToGame(Data{
+			Username: u.username,
+			Type:     "resync",
+		})
+	}
+	r.broadcastPrepUpdate()
+	return true, nil
+}
+
+func (l *Lobby) findReservation(session string) (*Room, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, r := range l.rooms {
+		r.mu.RLock()
+		for _, p := range r.players {
+			if p.user == nil && p.session == session {
+				r.mu.RUnlock()
+				return r, p.username
+			}
+		}
+		r.mu.RUnlock()
+	}
+	return nil, ""
+}
+
+// expireReservations does not expire anything while the game is paused.
+func (r *Room) expireReservations(now time.Time, timeout time.Duration) []string {
+	r.mu.RLock()
+	expired := []string{}
+	if r.paused {
+		r.mu.RUnlock()
+		return expired
+	}
+	for _, p := range r.players {
+		if p.user == nil && !p.reservedAt.IsZero() && now.Sub(p.reservedAt) > timeout {
+			expired = append(expired, p.username)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, username := range expired {
+		if r.isInGame(username) {
+			r.forwardToGame(Data{
+				Username: username,
+				Type:     "exit",
+			})
+		}
+		r.removePlayer(username)
+	}
+	return expired
+}
@@ -1,95 +1,236 @@
 package main
 
 import (
-	"encoding/json"
-	"log"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/ratelimit"
 )
 
 type User struct {
-	conn     *websocket.Conn
-	lobby    *Lobby
-	room     *Room
-	username string
-	toUser   chan Data
+	mu            *sync.Mutex
+	wmu           *sync.Mutex
+	qmu           *sync.Mutex
+	conn          *websocket.Conn
+	codec         protocol.Codec
+	remoteAddr    string
+	lobby         *Lobby
+	room          *Room
+	username      string
+	version       int
+	session       string
+	seq           uint64
+	replay        []Data
+	connectedAt   time.Time
+	lastActive    time.Time
+	detachedAt    time.Time
+	gone          bool
+	resyncPending *atomic.Bool
+	dropped       uint64
+	toUser        chan queued
+	done          chan struct{}
+	limiter       *ratelimit.Bucket
+	strikes       *ratelimit.Bucket
+	quotas        map[string]*ratelimit.Bucket
+}
+
+func (u *User) logger() *slog.Logger {
+	u.mu.Lock()
+	username, room := u.username, u.room
+	u.mu.Unlock()
+
+	if room != nil {
+		return slog.With("user", username, "room", room.id)
+	}
+	return slog.With("user", username)
+}
+
+// currentRoom returns the room u is in. Only the goroutine handling u's
+// messages changes it, under u.mu, but others read it.
+func (u *User) currentRoom() *Room {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.room
+}
+
+func (u *User) setRoom(r *Room) {
+	u.mu.Lock()
+	u.room = r
+	u.mu.Unlock()
 }
 
 func (u *User) disconnect() {
+	u.mu.Lock()
+	if u.gone {
+		u.mu.Unlock()
+		return
+	}
+	u.gone = true
+	u.mu.Unlock()
+	close(u.done)
+
 	if u.lobby != nil {
 		u.lobby.deleteUser(u)
 	}
-	if u.room != nil {
-		u.room.removePlayer(u.username)
-		if len(u.room.players) == 0 {
-			u.lobby.deleteRoom(u.room)
+	if r := u.currentRoom(); r != nil {
+		if r.isInGame(u.username) {
+			r.forwardToGame(Data{
+				Username: u.username,
+				Type:     "exit",
+			})
 		}
+		r.removePlayer(u.username)
+		u.lobby.deleteRoomIfEmpty(r)
 	}
-	u.conn.Close()
-	log.Printf("User %s disconnected", u.username)
+	u.logger().Info("user disconnected")
 }
 
 func (u *User) handleMessage() {
-	defer u.disconnect()
+	u.mu.Lock()
+	conn := u.conn
+	u.mu.Unlock()
+
+	pongWait := time.Duration(u.lobby.cfg.PongWait)
+	conn.SetReadLimit(int64(u.lobby.cfg.MaxMessageSize))
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
 	for {
-		_, msg, err := u.conn.ReadMessage()
+		_, msg, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error reading message: %s\n", err)
+				u.logger().Warn("cannot read message", "err", err)
 			}
+			u.detach(conn)
 			return
 		}
+		now := time.Now()
+		conn.SetReadDeadline(now.Add(pongWait))
+		u.touch()
+		if !u.allowMessage(now) {
+			continue
+		}
 
-		data := Data{}
-		err = json.Unmarshal(msg, &data)
+		data, err := u.codec.Decode(msg)
 		if err != nil {
-			log.Printf("error unmarshaling JSON: %s", string(msg))
+			u.logger().Warn("cannot decode message", "err", err)
+			messagesReceived.With("invalid").Inc()
+			u.sendError(err.Error())
 			continue
 		}
 
-		log.Printf("The server received the following data from %s: %v", u.username, data)
+		u.logger().Debug("received message", "type", data.Type)
+		if !u.allowType(data.Type, now) {
+			continue
+		}
+		messagesReceived.With(data.Type).Inc()
 		data.Username = u.username
 
 		switch data.Type {
 		case "ready":
-			u.handleReady()
+			u.handleReady(data.Body.(protocol.ReadyBody))
 		case "username":
-			u.handleUsername(data.Body)
+			u.handleUsername(data.Body.(protocol.UsernameBody))
 		case "prepNew":
 			u.handlePrepNew()
 		case "prepJoin":
-			u.handlePrepJoin(data.Body)
+			u.handlePrepJoin(data.Body.(protocol.PrepJoinBody))
 		case "prepLeave":
 			u.handlePrepLeave()
 		case "ruleset":
-			u.handleRuleset(data.Body)
+			u.handleRuleset(data.Body.(protocol.RulesetBody))
 		case "prepReady":
 			u.handlePrepReady()
 		case "prepUnready":
 			u.handlePrepUnready()
 		case "start":
 			u.handleStart()
-		case "roll":
-			u.room.forwardToGame(data)
-		case "act":
-			u.room.forwardToGame(data)
-		case "confirm":
-			u.room.forwardToGame(data)
-		case "exit":
-			u.room.forwardToGame(data)
+		case "resume":
+			if u.handleResume(data.Body.(protocol.ResumeBody)) {
+				return
+			}
+		case "ack":
+			u.handleAck(data.Body.(protocol.AckBody))
+		case "replay":
+			u.handleReplay(data.Body.(protocol.ReplayBody))
+		case "vote":
+			u.handleVote(data.Body.(protocol.VoteBody))
+		case game.InputResync:
+			u.resync()
 		default:
-			log.Print("unsupported type")
+			if game.IsInput(data.Type) {
+				u.handleGameMessage(data)
+			} else {
+				u.logger().Warn("unsupported message type", "type", data.Type)
+			}
 		}
 	}
 }
 
 func (u *User) sendMessage() {
-	for data := range u.toUser {
-		msg, err := json.Marshal(data)
-		if err != nil {
-			log.Printf("Error marshalling JSON %s", err)
+	ticker := time.NewTicker(u.lobby.cfg.PingPeriod())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			u.ping()
+		case q := <-u.toUser:
+			u.lobby.observeQueueDepth(len(u.toUser) + 1)
+
+			data := q.data
+			u.mu.Lock()
+			u.seq += q.dropped + 1
+			data.Seq = u.seq
+			u.replay = append(u.replay, data)
+			if len(u.replay) > u.lobby.cfg.ReplayBufferSize {
+				u.replay = slices.Delete(u.replay, 0, 1)
+			}
+			conn, codec, version := u.conn, u.codec, u.version
+			u.mu.Unlock()
+
+			if conn != nil {
+				u.wmu.Lock()
+				u.write(conn, codec, version, data)
+				u.wmu.Unlock()
+			}
+			if len(u.toUser) == 0 && u.resyncPending.CompareAndSwap(true, false) {
+				go u.resync()
+			}
+		case <-u.done:
 			return
 		}
-		u.conn.WriteMessage(websocket.TextMessage, msg)
 	}
 }
+
+// write must be called with u.wmu held.
+func (u *User) write(conn *websocket.Conn, codec protocol.Codec, version int, data Data) {
+	data = protocol.ForVersion(data, version)
+	msg, err := codec.Encode(data)
+	if err != nil {
+		u.logger().Error("cannot encode message", "type", data.Type, "err", err)
+		return
+	}
+	frameType := websocket.TextMessage
+	if codec.Binary() {
+		frameType = websocket.BinaryMessage
+	}
+	conn.SetWriteDeadline(time.Now().Add(time.Duration(u.lobby.cfg.WriteWait)))
+	err = conn.WriteMessage(frameType, msg)
+	if err != nil {
+		u.logger().Warn("cannot write message", "type", data.Type, "err", err)
+		conn.Close()
+		return
+	}
+	messagesSent.With(data.Type).Inc()
+}
@@ -1,60 +1,118 @@
 package main
 
 import (
-	"log"
+	"errors"
+
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/username"
 )
 
-func (u *User) handleReady() {
+func (u *User) handleReady(body protocol.ReadyBody) {
+	version, err := protocol.Negotiate(body.Versions)
+	if err != nil {
+		u.logger().Warn("handleReady: version negotiation failed", "err", err)
+		u.sendError(err.Error())
+		return
+	}
+	u.mu.Lock()
+	u.version = version
+	u.mu.Unlock()
+	u.sendVersion()
+	u.sendSession()
 	u.sendUsername()
 }
 
-func (u *User) handleUsername(body map[string]interface{}) {
-	username := body["username"].(string)
-	if u.lobby.findUserByUsername(username) != nil {
+func (u *User) handleUsername(body protocol.UsernameBody) {
+	if u.username != "" {
+		u.logger().Warn("handleUsername: user already has a username")
+		u.resync()
+		return
+	}
+
+	name, err := u.lobby.usernames.Check(body.Username)
+	if err != nil {
+		u.rejectUsername(err)
+		return
+	}
+	if b, ok := u.lobby.bans.check(banUser, name); ok {
+		u.logger().Info("handleUsername: username is banned", "username", name)
+		u.sendErrorCode("this username is banned: "+b.Reason, "banned")
 		u.sendUsername()
 		return
 	}
-	u.username = username
+	err = u.lobby.claimUsername(u, name)
+	if err != nil {
+		u.rejectUsername(err)
+		return
+	}
 	u.sendPrep()
 }
 
+func (u *User) rejectUsername(err error) {
+	code := "usernameInvalid"
+	switch {
+	case errors.Is(err, username.ErrEmpty):
+		code = "usernameEmpty"
+	case errors.Is(err, username.ErrTooLong):
+		code = "usernameTooLong"
+	case errors.Is(err, username.ErrInvalidChar):
+		code = "usernameInvalidChars"
+	case errors.Is(err, username.ErrMixedScript):
+		code = "usernameMixedScripts"
+	case errors.Is(err, username.ErrReserved):
+		code = "usernameReserved"
+	case errors.Is(err, username.ErrOffensive):
+		code = "usernameOffensive"
+	case errors.Is(err, ErrUsernameUsed):
+		code = "usernameTaken"
+	}
+	u.logger().Info("handleUsername: username rejected", "reason", code)
+	usernamesRejected.With(code).Inc()
+	u.sendErrorCode(err.Error(), code)
+	u.sendUsername()
+}
+
 func (u *User) handlePrepNew() {
-	if u.room != nil {
-		log.Printf("handlePrepNew: %s is already in room %s", u.username, u.room.id)
-		u.room.broadcastPrepUpdate()
+	if r := u.currentRoom(); r != nil {
+		u.logger().Warn("handlePrepNew: user is already in a room")
+		r.broadcastPrepUpdate()
 		return
 	}
 
 	r, err := u.lobby.newRoom()
 	if err != nil {
-		log.Printf("handlePrepNew: error creating new room: %s\n", err)
-		u.sendError("error creating new room")
+		u.logger().Warn("handlePrepNew: cannot create room", "err", err)
+		if errors.Is(err, ErrMaintenance) {
+			u.sendErrorCode("new rooms cannot be created during maintenance", "maintenance")
+		} else {
+			u.sendError("error creating new room")
+		}
 		u.sendPrep()
 		return
 	}
 
-	u.room = r
-	r.addPlayer(u)
+	u.setRoom(r)
+	err = r.addPlayer(u)
+	if err != nil {
+		u.logger().Warn("handlePrepNew: cannot add user to the new room", "err", err)
+		u.setRoom(nil)
+		u.lobby.deleteRoomIfEmpty(r)
+		u.sendError("error creating new room")
+		u.sendPrep()
+	}
 }
 
-func (u *User) handlePrepJoin(body map[string]interface{}) {
-	if u.room != nil {
-		log.Printf("handlePrepJoin: %s is already in room %s", u.username, u.room.id)
-		u.room.broadcastPrepUpdate()
+func (u *User) handlePrepJoin(body protocol.PrepJoinBody) {
+	if r := u.currentRoom(); r != nil {
+		u.logger().Warn("handlePrepJoin: user is already in a room")
+		r.broadcastPrepUpdate()
 		return
 	}
 
-	roomId, ok := body["roomId"].(string)
-	if !ok {
-		log.Print("handlePrepJoin: invalid room ID")
-		u.sendError("invalid room ID")
-		u.sendPrep()
-		return
-	}
-
-	r := u.lobby.findRoomById(roomId)
+	r := u.lobby.findRoomById(body.RoomId)
 	if r == nil {
-		log.Print("handlePrepJoin: room not found")
+		u.logger().Warn("handlePrepJoin: room not found", "roomId", body.RoomId)
 		u.sendError("room not found")
 		u.sendPrep()
 		return
@@ -62,69 +120,125 @@ func (u *User) handlePrepJoin(body map[string]interface{}) {
 
 	err := r.addPlayer(u)
 	if err != nil {
-		log.Printf("handlePrepJoin: error adding user to the room: %s", err)
+		u.logger().Warn("handlePrepJoin: cannot add user to the room", "roomId", body.RoomId, "err", err)
 		u.sendError("error joining the room")
 		u.sendPrep()
 		return
 	}
-	u.room = r
+	u.setRoom(r)
 }
 
 func (u *User) handlePrepLeave() {
-	if u.room == nil {
-		log.Printf("handlePrepLeave: %s is already not in any room", u.username)
+	r := u.currentRoom()
+	if r == nil {
+		u.logger().Warn("handlePrepLeave: user is already not in any room")
 		u.sendPrep()
 		return
 	}
-	u.room.removePlayer(u.username)
-	u.room = nil
+	r.removePlayer(u.username)
+	u.setRoom(nil)
+	u.lobby.deleteRoomIfEmpty(r)
 	u.sendPrep()
 }
 
-func (u *User) handleRuleset(body map[string]interface{}) {
-	if u.room == nil {
-		log.Printf("handlePrepReady: %s is not in any room", u.username)
+func (u *User) handleRuleset(body protocol.RulesetBody) {
+	r := u.currentRoom()
+	if r == nil {
+		u.logger().Warn("handleRuleset: user is not in any room")
 		u.sendPrep()
 		return
 	}
-	i := int(body["ruleset"].(float64))
-	u.room.setIndexRuleset(i)
+	var g game.Game
+	if body.Game != "" {
+		var err error
+		g, err = game.Lookup(body.Game)
+		if err != nil {
+			u.logger().Warn("handleRuleset: unknown game", "game", body.Game)
+			u.sendErrorCode(err.Error(), "unknownGame")
+			r.broadcastPrepUpdate()
+			return
+		}
+	}
+	err := r.setRuleset(g, body)
+	if err != nil {
+		u.logger().Warn("handleRuleset: unknown rule set", "game", body.Game, "ruleset", body.Ruleset)
+		u.sendErrorCode(err.Error(), "invalidRuleset")
+		r.broadcastPrepUpdate()
+	}
 }
 
 func (u *User) handlePrepReady() {
-	if u.room == nil {
-		log.Printf("handlePrepReady: %s is not in any room", u.username)
+	r := u.currentRoom()
+	if r == nil {
+		u.logger().Warn("handlePrepReady: user is not in any room")
 		u.sendPrep()
 		return
 	}
-	u.room.setReady(u.username)
+	r.setReady(u.username)
 }
 
 func (u *User) handlePrepUnready() {
-	if u.room == nil {
-		log.Printf("handlePrepUnready: %s is not in any room", u.username)
+	r := u.currentRoom()
+	if r == nil {
+		u.logger().Warn("handlePrepUnready: user is not in any room")
 		u.sendPrep()
 		return
 	}
-	u.room.setUnready(u.username)
+	r.setUnready(u.username)
 }
 
 func (u *User) handleStart() {
-	if u.room == nil {
-		log.Printf("handlePrepUnready: %s is not in any room", u.username)
+	r := u.currentRoom()
+	if r == nil {
+		u.logger().Warn("handleStart: user is not in any room")
 		u.sendPrep()
 		return
 	}
-	if u.room.indexPlayer(u.username) != 0 {
-		log.Printf("handlePrepUnready: %s is not the host", u.username)
-		u.room.broadcastPrepUpdate()
+	err := r.startGame(u.username)
+	if err == nil {
 		return
 	}
-	if !u.room.isAllReady() {
-		log.Printf("handlePrepUnready: not everyone is ready")
-		u.room.broadcastPrepUpdate()
+	u.logger().Warn("handleStart: cannot start game", "err", err)
+	switch {
+	case errors.Is(err, ErrNotHost), errors.Is(err, ErrNotAllReady), errors.Is(err, ErrGameInProgress):
+	case errors.Is(err, game.ErrPlayerCount):
+		u.sendErrorCode(err.Error(), "playerCount")
+	default:
+		u.sendErrorCode(err.Error(), "invalidRuleset")
+	}
+	r.broadcastPrepUpdate()
+}
+
+func (u *User) handleVote(body protocol.VoteBody) {
+	r := u.currentRoom()
+	if r == nil {
+		u.logger().Warn("handleVote: user is not in any room")
+		u.sendPrep()
 		return
 	}
+	err := r.vote(u.username, body)
+	if err != nil {
+		u.logger().Warn("handleVote: vote rejected", "motion", body.Motion, "err", err)
+		u.sendErrorCode(err.Error(), "invalidVote")
+	}
+}
 
-	u.room.startGame()
+func (u *User) handleGameMessage(data Data) {
+	r := u.currentRoom()
+	var g game.Game
+	ok := false
+	if r != nil {
+		g, ok = r.currentGame()
+	}
+	if !ok {
+		u.logger().Warn("handleGameMessage: user is not in any game", "type", data.Type)
+		u.sendError("not in a game")
+		return
+	}
+	if !game.Accepts(g, data.Type) {
+		u.logger().Warn("handleGameMessage: message is not for this game", "type", data.Type, "game", g.Name())
+		u.sendError("unsupported message in this game")
+		return
+	}
+	r.forwardToGame(data)
 }
@@ -0,0 +1,86 @@
+package main
+
+// queued is a message waiting in a user's send queue.
+type queued struct {
+	data Data
+	// dropped counts the messages dropped just before this one, whose seqs
+	// sendMessage skips so that the client sees the gap.
+	dropped uint64
+}
+
+func (u *User) enqueue(d Data) {
+	if u == nil {
+		return
+	}
+
+	select {
+	case <-u.done:
+		return
+	default:
+	}
+
+	u.qmu.Lock()
+	select {
+	case u.toUser <- queued{data: d, dropped: u.dropped}:
+		u.dropped = 0
+		u.qmu.Unlock()
+	default:
+		u.dropped++
+		u.qmu.Unlock()
+		u.fallBehind(d)
+	}
+}
+
+func (u *User) fallBehind(d Data) {
+	u.lobby.queueDropped.Add(1)
+	u.resyncPending.Store(true)
+	if u.lobby.cfg.SlowClientPolicy != "disconnect" {
+		u.logger().Warn("send queue is full, dropped message", "type", d.Type)
+		return
+	}
+
+	u.mu.Lock()
+	conn := u.conn
+	u.mu.Unlock()
+	if conn != nil {
+		u.logger().Warn("send queue is full, closing connection")
+		u.lobby.queueDisconnects.Add(1)
+		conn.Close()
+	}
+}
+
+func (l *Lobby) observeQueueDepth(depth int) {
+	for {
+		max := l.queueMaxDepth.Load()
+		if int64(depth) <= max || l.queueMaxDepth.CompareAndSwap(max, int64(depth)) {
+			return
+		}
+	}
+}
+
+type queueStats struct {
+	Capacity    int            `json:"capacity"`
+	MaxDepth    int64          `json:"maxDepth"`
+	Dropped     int64          `json:"dropped"`
+	Disconnects int64          `json:"disconnects"`
+	Depths      map[string]int `json:"depths"`
+}
+
+func (l *Lobby) queueStats() queueStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := queueStats{
+		Capacity:    l.cfg.SendQueueSize,
+		MaxDepth:    l.queueMaxDepth.Load(),
+		Dropped:     l.queueDropped.Load(),
+		Disconnects: l.queueDisconnects.Load(),
+		Depths:      map[string]int{},
+	}
+	for _, u := range l.users {
+		if u.username != "" {
+			stats.Depths[u.username] = len(u.toUser)
+		}
+	}
+	return stats
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	cantstop "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/cant_stop"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/game"
+)
+
+func TestDroppedResultIsResent(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := s.login("alice")
+	bob := s.login("bob")
+	s.newRoom(cantstop.Name, 4, alice, bob)
+	alice.send("start", nil)
+	start := decode[cantstop.StartBody](t, alice.skipTo("start"))
+	first := s.clientNamed(start.Usernames[0], alice, bob)
+	first.skipTo("roll")
+
+	// Hold the writer and fill the queue, so that the log and the result of
+	// the roll are dropped.
+	u := s.lobby.findUserByUsername(first.name)
+	u.wmu.Lock()
+	for len(u.toUser) < cap(u.toUser) {
+		u.enqueue(game.DataLog("filler"))
+	}
+	dropped := s.lobby.queueDropped.Load()
+	first.send("roll", nil)
+	s.waitFor("messages to be dropped", func() bool { return s.lobby.queueDropped.Load() == dropped+2 })
+	u.wmu.Unlock()
+
+	// The resync after the fillers skips the seqs of the dropped messages.
+	prev, m := first.next(), first.next()
+	for m.Type != "start" {
+		prev, m = m, first.next()
+	}
+	if m.Seq != prev.Seq+3 {
+		t.Fatalf("got resync at seq %d after %d, want %d", m.Seq, prev.Seq, prev.Seq+3)
+	}
+	result := decode[cantstop.ResultBody](t, first.skipTo("result"))
+	if len(result.Points) != 4 {
+		t.Fatalf("got %d dice after resync, want 4", len(result.Points))
+	}
+}
@@ -1,17 +1,56 @@
 package main
 
-import cantstop "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/cant_stop"
+import "github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
 
-type Data = cantstop.Data
+type Data = protocol.Data
 
-func (u User) sendError(errMsg string) {
+func (u *User) sendError(errMsg string) {
 	data := Data{
 		Type: "error",
-		Body: map[string]interface{}{
-			"error": errMsg,
+		Body: protocol.ErrorBody{
+			Error: errMsg,
 		},
 	}
-	u.toUser <- data
+	u.enqueue(data)
+}
+
+func (u *User) sendErrorCode(errMsg string, code string) {
+	data := Data{
+		Type: "error",
+		Body: protocol.ErrorBody{
+			Error: errMsg,
+			Code:  code,
+		},
+	}
+	u.enqueue(data)
+}
+
+func (u *User) sendSession() {
+	u.mu.Lock()
+	session := u.session
+	u.mu.Unlock()
+
+	data := Data{
+		Type: "session",
+		Body: protocol.SessionBody{
+			Session: session,
+		},
+	}
+	u.enqueue(data)
+}
+
+func (u *User) sendVersion() {
+	u.mu.Lock()
+	version := u.version
+	u.mu.Unlock()
+
+	data := Data{
+		Type: "version",
+		Body: protocol.VersionBody{
+			Version: version,
+		},
+	}
+	u.enqueue(data)
 }
 
 func (u *User) sendUsername() {
@@ -19,13 +58,13 @@ func (u *User) sendUsername() {
 		Type: "username",
 		Body: nil,
 	}
-	u.toUser <- data
+	u.enqueue(data)
 }
 
-func (u User) sendPrep() {
+func (u *User) sendPrep() {
 	data := Data{
 		Type: "prep",
 		Body: nil,
 	}
-	u.toUser <- data
+	u.enqueue(data)
 }
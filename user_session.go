@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kuangyuwu/boardgame-backend-cant-stop/internal/protocol"
+)
+
+func randSession() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (u *User) detach(conn *websocket.Conn) {
+	conn.Close()
+
+	u.mu.Lock()
+	if u.conn != conn || u.gone {
+		u.mu.Unlock()
+		return
+	}
+	u.conn = nil
+	detachedAt := time.Now()
+	u.detachedAt = detachedAt
+	u.mu.Unlock()
+
+	if u.username == "" || !u.lobby.cfg.EnableSessionResume {
+		u.disconnect()
+		return
+	}
+
+	resumeGrace := time.Duration(u.lobby.cfg.ResumeGrace)
+	u.logger().Info("user detached, waiting for resume", "grace", resumeGrace)
+	time.AfterFunc(resumeGrace, func() {
+		u.mu.Lock()
+		expired := u.conn == nil && u.detachedAt.Equal(detachedAt)
+		u.mu.Unlock()
+		if expired {
+			u.disconnect()
+		}
+	})
+}
+
+func (u *User) attach(conn *websocket.Conn, codec protocol.Codec, version int, lastSeq uint64) (ok bool, replayed bool) {
+	u.wmu.Lock()
+	defer u.wmu.Unlock()
+
+	u.mu.Lock()
+	if u.gone {
+		u.mu.Unlock()
+		return false, false
+	}
+	if u.conn != nil {
+		u.conn.Close()
+	}
+	u.conn = conn
+	u.codec = codec
+	u.version = version
+	u.detachedAt = time.Time{}
+	missed, replayed := u.missedSince(lastSeq + 1)
+	u.mu.Unlock()
+
+	u.write(conn, codec, version, Data{
+		Type: "version",
+		Body: protocol.VersionBody{
+			Version: version,
+		},
+	})
+	for _, d := range missed {
+		u.write(conn, codec, version, d)
+	}
+	return true, replayed
+}
+
+// missedSince must be called with u.mu held. If the messages from seq from
+// onward are no longer buffered, it returns a replayUnavailable notice instead.
+func (u *User) missedSince(from uint64) ([]Data, bool) {
+	if from > u.seq {
+		return nil, true
+	}
+	oldest := u.seq + 1
+	if len(u.replay) > 0 {
+		oldest = u.replay[0].Seq
+	}
+	if from < oldest {
+		return []Data{{
+			Type: "replayUnavailable",
+			Body: protocol.ReplayUnavailableBody{
+				From:   from,
+				Oldest: oldest,
+			},
+		}}, false
+	}
+	missed := []Data{}
+	for _, d := range u.replay {
+		if d.Seq >= from {
+			missed = append(missed, d)
+		}
+	}
+	return missed, true
+}
+
+func (u *User) handleResume(body protocol.ResumeBody) bool {
+	if !u.lobby.cfg.EnableSessionResume {
+		u.sendErrorCode("session resume is disabled", "resumeFailed")
+		return false
+	}
+
+	version, err := protocol.Negotiate(body.Versions)
+	if err != nil {
+		u.logger().Warn("handleResume: version negotiation failed", "err", err)
+		u.sendError(err.Error())
+		return false
+	}
+
+	o := u.lobby.findUserBySession(body.Session)
+	if o == nil {
+		if r, username := u.lobby.findReservation(body.Session); r != nil {
+			u.resumeReservation(r, username, body.Session, version)
+			return false
+		}
+	}
+	if o == nil || o == u {
+		u.logger().Warn("handleResume: session not found")
+		u.sendErrorCode("session not found", "resumeFailed")
+		return false
+	}
+
+	u.mu.Lock()
+	conn := u.conn
+	u.conn = nil
+	u.gone = true
+	u.mu.Unlock()
+
+	ok, replayed := o.attach(conn, u.codec, version, body.LastSeq)
+	if !ok {
+		u.mu.Lock()
+		u.conn = conn
+		u.gone = false
+		u.mu.Unlock()
+		o.logger().Info("handleResume: session has expired")
+		u.sendErrorCode("session expired", "resumeFailed")
+		return false
+	}
+
+	u.lobby.deleteUser(u)
+	close(u.done)
+	o.logger().Info("user resumed their session")
+
+	go o.handleMessage()
+	if !replayed {
+		o.resync()
+	}
+	return true
+}
+
+func (u *User) resumeReservation(r *Room, username string, session string, version int) {
+	if u.currentRoom() != nil {
+		u.logger().Warn("resumeReservation: user is already in a room", "reservedFor", username)
+		u.sendErrorCode("session cannot be resumed", "resumeFailed")
+		return
+	}
+	err := u.lobby.claimUsername(u, username)
+	if err != nil {
+		u.logger().Warn("resumeReservation: cannot resume reserved seat", "reservedFor", username, "err", err)
+		u.sendErrorCode("session cannot be resumed", "resumeFailed")
+		return
+	}
+
+	u.lobby.setSession(u, session)
+	u.mu.Lock()
+	u.version = version
+	u.mu.Unlock()
+	u.sendVersion()
+	u.sendSession()
+	err = r.addPlayer(u)
+	if err != nil {
+		u.logger().Warn("resumeReservation: cannot claim reserved seat", "err", err)
+		u.sendErrorCode("session cannot be resumed", "resumeFailed")
+		u.sendPrep()
+		return
+	}
+	u.setRoom(r)
+}
+
+func (u *User) handleAck(body protocol.AckBody) {
+	u.mu.Lock()
+	if body.Seq > u.seq {
+		sent := u.seq
+		u.mu.Unlock()
+		u.logger().Warn("handleAck: acknowledged more messages than were sent", "ack", body.Seq, "sent", sent)
+		return
+	}
+	defer u.mu.Unlock()
+
+	i := 0
+	for i < len(u.replay) && u.replay[i].Seq <= body.Seq {
+		i++
+	}
+	u.replay = u.replay[i:]
+}
+
+func (u *User) handleReplay(body protocol.ReplayBody) {
+	u.wmu.Lock()
+	u.mu.Lock()
+	missed, ok := u.missedSince(body.From)
+	conn, codec, version := u.conn, u.codec, u.version
+	u.mu.Unlock()
+	if conn != nil {
+		for _, d := range missed {
+			u.write(conn, codec, version, d)
+		}
+	}
+	u.wmu.Unlock()
+
+	if !ok {
+		u.resync()
+	}
+}
+
+func (u *User) resync() {
+	r := u.currentRoom()
+	switch {
+	case u.username == "":
+		u.sendUsername()
+	case r == nil:
+		u.sendPrep()
+	case r.isInGame(u.username):
+		r.forwardToGame(Data{
+			Username: u.username,
+			Type:     "resync",
+		})
+	default:
+		r.broadcastPrepUpdate()
+	}
+}